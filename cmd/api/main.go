@@ -3,36 +3,103 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/emanuelef/yt-dl-api-go/internal/cache"
 	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
 	"github.com/emanuelef/yt-dl-api-go/internal/handler"
+	"github.com/emanuelef/yt-dl-api-go/internal/metrics"
 	"github.com/emanuelef/yt-dl-api-go/internal/middleware"
+	"github.com/emanuelef/yt-dl-api-go/internal/netguard"
+	"github.com/emanuelef/yt-dl-api-go/internal/notifier"
+	"github.com/emanuelef/yt-dl-api-go/internal/ratecount"
+	"github.com/emanuelef/yt-dl-api-go/internal/sign"
 	"github.com/emanuelef/yt-dl-api-go/internal/storage"
 )
 
 // Config holds all application configuration.
 type Config struct {
-	Port               string
-	AllowedOrigins     []string
-	TurnstileSecret    string
-	TurnstileSkip      bool
-	RateLimitPerMinute int
-	R2AccountID        string
-	R2AccessKeyID      string
-	R2SecretAccessKey  string
-	R2BucketName       string
-	R2PublicURL        string
-	MaxDurationSeconds int
-	MaxFileSizeBytes   int64
-	TempDir            string
+	Port                   string
+	AllowedOrigins         []string
+	TurnstileSecret        string
+	TurnstileSkip          bool
+	TurnstileAction        string
+	RateLimitPerMinute     int
+	InfoRateLimitPerMinute int
+	FileRateLimitPerMinute int
+	RateLimitBackend       string
+	RedisURL               string
+	R2AccountID            string
+	R2AccessKeyID          string
+	R2SecretAccessKey      string
+	R2BucketName           string
+	R2PublicURL            string
+	R2StartupCheck         bool
+	R2PartSize             int64
+	R2UploadConcurrency    int
+	R2Endpoint             string
+	PresignExpiry          time.Duration
+	MaxDurationSeconds     int
+	MaxFileSizeBytes       int64
+	TempDir                string
+	LocalBaseURL           string
+	LocalURLSecret         string
+	MaxErrorLength         int
+	SingleFlight           bool
+	DownloadMode           string
+	DomainPatterns         []string
+	AllowedDomains         []string
+	AllowedDomainsFile     string
+	AllowedDomainsMode     string
+	WriteTimeout           time.Duration
+	MaxConnections         int
+	ByteQuotaBytes         int64
+	ByteQuotaWindow        time.Duration
+	SMTPHost               string
+	SMTPPort               int
+	SMTPUsername           string
+	SMTPPassword           string
+	SMTPFrom               string
+	NotifyMaxPerHour       int
+	MaxConcurrentInfo      int
+	CodecPreference        []string
+	AdminAPIKey            string
+	VideoCacheTTL          time.Duration
+	VideoCacheCleanup      time.Duration
+	PreviewMaxSeconds      int
+	PlaylistMaxItems       int
+	PlaylistUploads        int
+	PlaylistPartial        bool
+	SponsorCategories      []string
+	PreviewMaxFileSize     int64
+	MaxDowngrades          int
+	MaxRetries             int
+	MetadataOnFailure      bool
+	MaxJobDuration         time.Duration
+	CacheTTLOverrides      map[string]time.Duration
+	PlatformConcurrency    map[string]int
+	CookiesFile            string
+	AllowRequestCookies    bool
+	Proxy                  string
+	AllowRequestProxy      bool
+	MaxDownloadRate        string
+	APIKeys                []string
+	APIKeysFile            string
+	APIKeysRequired        bool
+	KeyRateLimits          map[string]int
+	KeyDailyQuota          int
 }
 
 func main() {
@@ -45,81 +112,401 @@ func main() {
 	}
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})))
 
+	if cfg.DownloadMode != handler.ModeAllowlist && cfg.DownloadMode != handler.ModeSSRFOnly {
+		slog.Error("Invalid DOWNLOAD_MODE", "mode", cfg.DownloadMode)
+		os.Exit(1)
+	}
+	if cfg.Proxy != "" {
+		if err := downloader.ValidateProxy(cfg.Proxy); err != nil {
+			slog.Error("Invalid YTDLP_PROXY", "error", err)
+			os.Exit(1)
+		}
+	}
+	for _, c := range cfg.SponsorCategories {
+		if !downloader.IsSponsorCategory(c) {
+			slog.Error("Invalid SPONSORBLOCK_CATEGORIES", "category", c, "allowed", downloader.SponsorCategories)
+			os.Exit(1)
+		}
+	}
+	var rateLimit int64
+	if cfg.MaxDownloadRate != "" {
+		var err error
+		if rateLimit, err = downloader.ParseRate(cfg.MaxDownloadRate); err != nil {
+			slog.Error("Invalid MAX_DOWNLOAD_RATE", "error", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.DownloadMode == handler.ModeSSRFOnly {
+		slog.Warn("DOWNLOAD_MODE=ssrf_only: domain allowlist disabled, any public host can be downloaded")
+	}
+	allowedDomains, err := loadAllowedDomains(cfg)
+	if err != nil {
+		slog.Error("Invalid domain allowlist", "error", err)
+		os.Exit(1)
+	}
+	if cfg.DownloadMode == handler.ModeAllowlist {
+		slog.Info("Domain allowlist", "domains", allowedDomains, "patterns", cfg.DomainPatterns)
+	}
+
 	// Initialize components
-	dl := downloader.New(cfg.TempDir, cfg.MaxDurationSeconds, cfg.MaxFileSizeBytes)
+	dl := downloader.New(downloader.Config{
+		TempDir:           cfg.TempDir,
+		MaxDuration:       cfg.MaxDurationSeconds,
+		MaxFileSize:       cfg.MaxFileSizeBytes,
+		MaxErrorLength:    cfg.MaxErrorLength,
+		MaxConcurrentInfo: cfg.MaxConcurrentInfo,
+		CodecPreference:   cfg.CodecPreference,
+		CookiesFile:       cfg.CookiesFile,
+		Proxy:             cfg.Proxy,
+		RateLimit:         rateLimit,
+	})
 
 	var store handler.Storage
+	var degraded string
 	if cfg.R2AccountID != "" {
-		r2, err := storage.NewR2(context.Background(), cfg.R2AccountID, cfg.R2AccessKeyID, cfg.R2SecretAccessKey, cfg.R2BucketName, cfg.R2PublicURL)
+		r2, err := storage.NewR2(context.Background(), storage.R2Config{
+			AccountID:         cfg.R2AccountID,
+			AccessKeyID:       cfg.R2AccessKeyID,
+			SecretAccessKey:   cfg.R2SecretAccessKey,
+			Bucket:            cfg.R2BucketName,
+			PublicURL:         cfg.R2PublicURL,
+			PresignExpiry:     cfg.PresignExpiry,
+			PartSize:          cfg.R2PartSize,
+			UploadConcurrency: cfg.R2UploadConcurrency,
+			Endpoint:          cfg.R2Endpoint,
+		})
+		if err == nil && cfg.R2StartupCheck {
+			err = checkR2(r2)
+			if err != nil {
+				degraded = "R2 unreachable, using local storage"
+			}
+		}
 		if err != nil {
 			slog.Warn("R2 not configured, using local storage", "error", err)
-			store = storage.NewLocal(cfg.TempDir)
+			store = newLocal(cfg)
 		} else {
 			store = r2
 		}
 	} else {
-		store = storage.NewLocal(cfg.TempDir)
+		store = newLocal(cfg)
+	}
+
+	notifiers := notifier.Multi{notifier.NewWebhook(netguard.NewHTTPClient(5 * time.Second))}
+	if cfg.SMTPHost != "" {
+		notifiers = append(notifiers, notifier.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.NotifyMaxPerHour))
 	}
 
-	h := handler.New(dl, store)
+	videoCache := cache.New(cfg.VideoCacheTTL, cfg.VideoCacheCleanup)
+	defer videoCache.Stop()
+
+	h := handler.New(dl, store, videoCache, notifiers, handler.Config{
+		DownloadMode:              cfg.DownloadMode,
+		AllowedDomains:            allowedDomains,
+		DomainPatterns:            cfg.DomainPatterns,
+		SingleFlight:              cfg.SingleFlight,
+		DegradedReason:            degraded,
+		ByteQuota:                 cfg.ByteQuotaBytes,
+		ByteQuotaWindow:           cfg.ByteQuotaWindow,
+		PreviewMaxSeconds:         cfg.PreviewMaxSeconds,
+		PlaylistMaxItems:          cfg.PlaylistMaxItems,
+		PlaylistUploadConcurrency: cfg.PlaylistUploads,
+		PlaylistPartialResults:    cfg.PlaylistPartial,
+		SponsorCategories:         cfg.SponsorCategories,
+		PreviewMaxFileSize:        cfg.PreviewMaxFileSize,
+		MaxDowngrades:             cfg.MaxDowngrades,
+		MaxRetries:                cfg.MaxRetries,
+		MetadataOnFailure:         cfg.MetadataOnFailure,
+		MaxJobDuration:            cfg.MaxJobDuration,
+		CacheTTLOverrides:         cfg.CacheTTLOverrides,
+		MaxDuration:               cfg.MaxDurationSeconds,
+		MaxFileSize:               cfg.MaxFileSizeBytes,
+		PlatformConcurrency:       cfg.PlatformConcurrency,
+		AllowRequestCookies:       cfg.AllowRequestCookies,
+		AllowRequestProxy:         cfg.AllowRequestProxy,
+		KeyDailyQuota:             int64(cfg.KeyDailyQuota),
+	})
+
+	// Info routes spawn yt-dlp too but are far cheaper than downloads, so
+	// they are counted separately
+	var downloadCounter, infoCounter, fileCounter middleware.Counter
+	switch cfg.RateLimitBackend {
+	case "memory":
+	case "redis":
+		client, err := ratecount.New(cfg.RedisURL, time.Second)
+		if err != nil {
+			slog.Error("Invalid REDIS_URL", "error", err)
+			os.Exit(1)
+		}
+		defer client.Close()
+		downloadCounter = ratecount.NewCounter(client, "ratelimit:download:", time.Minute)
+		infoCounter = ratecount.NewCounter(client, "ratelimit:info:", time.Minute)
+		fileCounter = ratecount.NewCounter(client, "ratelimit:files:", time.Minute)
+	default:
+		slog.Error("Invalid RATE_LIMIT_BACKEND", "backend", cfg.RateLimitBackend)
+		os.Exit(1)
+	}
+	limit := middleware.NewRateLimiter(cfg.RateLimitPerMinute, cfg.KeyRateLimits, downloadCounter).Wrap
+	limitInfo := middleware.NewRateLimiter(cfg.InfoRateLimitPerMinute, nil, infoCounter).Wrap
+	limitFiles := middleware.NewRateLimiter(cfg.FileRateLimitPerMinute, nil, fileCounter).Wrap
 
 	// Build middleware chain
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/health", h.Health)
-	mux.HandleFunc("POST /api/download", h.Download)
+	mux.HandleFunc("GET /api/ready", h.Ready)
+	// Downloads run synchronously, so the route outlives the server write timeout
+	mux.Handle("POST /api/download", limit(middleware.WriteTimeout(http.HandlerFunc(h.Download), cfg.MaxJobDuration+time.Minute)))
 	mux.HandleFunc("OPTIONS /api/download", h.Options)
+	mux.Handle("GET /api/info", limitInfo(http.HandlerFunc(h.Info)))
+	// Large files stream for longer than the server's write timeout
+	mux.Handle("GET "+storage.FilesPath+"{name}", limitFiles(middleware.WriteTimeout(http.HandlerFunc(h.File), 0)))
+	if cfg.AdminAPIKey != "" {
+		mux.Handle("GET /api/info/raw", limitInfo(middleware.AdminKey(http.HandlerFunc(h.RawInfo), cfg.AdminAPIKey)))
+		// Admin routes need the admin key, so they are not rate limited and
+		// never use up the admin's download allowance
+		mux.Handle("GET /api/metrics", middleware.AdminKey(metrics.Handler(), cfg.AdminAPIKey))
+		mux.Handle("POST /api/admin/prewarm", middleware.AdminKey(middleware.WriteTimeout(http.HandlerFunc(h.Prewarm), handler.PrewarmTimeout+time.Minute), cfg.AdminAPIKey))
+	}
 
 	// Apply middleware (order matters: outermost first)
 	var httpHandler http.Handler = mux
-	httpHandler = middleware.RateLimit(httpHandler, cfg.RateLimitPerMinute)
 	if !cfg.TurnstileSkip {
-		httpHandler = middleware.Turnstile(httpHandler, cfg.TurnstileSecret)
+		httpHandler = middleware.Turnstile(httpHandler, cfg.TurnstileSecret, cfg.TurnstileAction)
+	}
+	apiKeys, err := loadAPIKeys(cfg)
+	if err != nil {
+		slog.Error("Failed to load API keys", "error", err)
+		os.Exit(1)
+	}
+	if len(apiKeys) > 0 {
+		// The admin key is valid wherever an API key is
+		if cfg.AdminAPIKey != "" {
+			apiKeys = append(apiKeys, cfg.AdminAPIKey)
+		}
+		httpHandler = middleware.APIKey(httpHandler, apiKeys, cfg.APIKeysRequired)
+	} else if cfg.APIKeysRequired {
+		slog.Error("API_KEYS_REQUIRED is set but no API keys are configured")
+		os.Exit(1)
 	}
 	httpHandler = middleware.CORS(httpHandler, cfg.AllowedOrigins)
+	if cfg.MaxConnections > 0 {
+		httpHandler = middleware.MaxConcurrent(httpHandler, cfg.MaxConnections)
+	}
 	httpHandler = middleware.Logger(httpHandler)
 
-	server := &http.Server{
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	slog.Info("Server starting", "port", cfg.Port)
+	if err := serve(ctx, newServer(cfg, httpHandler), 30*time.Second); err != nil {
+		slog.Error("Server error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newServer returns the HTTP server for handler, configured from cfg.
+func newServer(cfg *Config, handler http.Handler) *http.Server {
+	return &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      httpHandler,
+		Handler:      handler,
 		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 10 * time.Minute, // Long for video downloads
+		WriteTimeout: cfg.WriteTimeout, // Long-running routes extend their own deadline
 		IdleTimeout:  60 * time.Second,
 	}
+}
 
-	// Graceful shutdown
-	go func() {
-		slog.Info("Server starting", "port", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("Server error", "error", err)
-			os.Exit(1)
-		}
-	}()
+// serve runs server until ctx is done, then shuts it down gracefully,
+// giving in-flight requests up to shutdownTimeout. It returns nil after a
+// clean shutdown and an error if the server fails to start or to drain.
+func serve(ctx context.Context, server *http.Server, shutdownTimeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- server.ListenAndServe() }()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
 
 	slog.Info("Shutting down...")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// checkR2 confirms at startup that the R2 credentials and bucket work.
+func checkR2(r2 *storage.R2) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	server.Shutdown(ctx)
+	if err := r2.Check(ctx); err != nil {
+		slog.Error("R2 connectivity check failed", "error", err)
+		return err
+	}
+	slog.Info("R2 connectivity check passed")
+	return nil
+}
+
+// loadAPIKeys returns the keys from API_KEYS and from API_KEYS_FILE, which
+// lists one key per line; blank lines and lines starting with # are skipped.
+func loadAPIKeys(cfg *Config) ([]string, error) {
+	var keys []string
+	for _, k := range cfg.APIKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if cfg.APIKeysFile == "" {
+		return keys, nil
+	}
+	data, err := os.ReadFile(cfg.APIKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	return keys, nil
+}
+
+// hostnamePattern matches a plausible lowercase DNS name with at least two
+// labels and an alphabetic top-level domain.
+var hostnamePattern = regexp.MustCompile(`^(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// loadAllowedDomains builds the domain allowlist from ALLOWED_DOMAINS and
+// ALLOWED_DOMAINS_FILE, a JSON array of domains. Depending on
+// ALLOWED_DOMAINS_MODE they extend or replace the built-in list, which is
+// used as is when neither is set.
+func loadAllowedDomains(cfg *Config) ([]string, error) {
+	if cfg.AllowedDomainsMode != "extend" && cfg.AllowedDomainsMode != "replace" {
+		return nil, fmt.Errorf("ALLOWED_DOMAINS_MODE must be extend or replace, got %q", cfg.AllowedDomainsMode)
+	}
+	configured := cfg.AllowedDomains
+	if cfg.AllowedDomainsFile != "" {
+		data, err := os.ReadFile(cfg.AllowedDomainsFile)
+		if err != nil {
+			return nil, err
+		}
+		var fromFile []string
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.AllowedDomainsFile, err)
+		}
+		configured = append(configured, fromFile...)
+	}
+
+	var domains []string
+	for _, d := range configured {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" {
+			continue
+		}
+		if !hostnamePattern.MatchString(d) {
+			return nil, fmt.Errorf("%q is not a valid domain", d)
+		}
+		domains = append(domains, d)
+	}
+	if len(domains) == 0 {
+		return handler.DefaultAllowedDomains, nil
+	}
+	if cfg.AllowedDomainsMode == "extend" {
+		domains = append(slices.Clone(handler.DefaultAllowedDomains), domains...)
+	}
+	return domains, nil
+}
+
+// newLocal creates local storage with signed file links. Without a
+// configured secret a random one is used, so links end at restart.
+func newLocal(cfg *Config) *storage.Local {
+	secret := []byte(cfg.LocalURLSecret)
+	if len(secret) == 0 {
+		slog.Warn("LOCAL_URL_SECRET not set, file links will not survive a restart")
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return storage.NewLocal(storage.LocalConfig{
+		Dir:        cfg.TempDir,
+		BaseURL:    cfg.LocalBaseURL,
+		Signer:     sign.New(secret),
+		LinkExpiry: cfg.PresignExpiry,
+	})
 }
 
 func loadConfig() *Config {
 	return &Config{
-		Port:               getEnv("PORT", "8080"),
-		AllowedOrigins:     splitEnv("ALLOWED_ORIGINS", []string{"*"}),
-		TurnstileSecret:    os.Getenv("TURNSTILE_SECRET_KEY"),
-		TurnstileSkip:      os.Getenv("TURNSTILE_SKIP") == "true",
-		RateLimitPerMinute: getEnvInt("RATE_LIMIT_RPM", 10),
-		R2AccountID:        os.Getenv("R2_ACCOUNT_ID"),
-		R2AccessKeyID:      os.Getenv("R2_ACCESS_KEY_ID"),
-		R2SecretAccessKey:  os.Getenv("R2_SECRET_ACCESS_KEY"),
-		R2BucketName:       getEnv("R2_BUCKET_NAME", "video-downloads"),
-		R2PublicURL:        os.Getenv("R2_PUBLIC_URL"),
-		MaxDurationSeconds: getEnvInt("MAX_DURATION_SECONDS", 1800),
-		MaxFileSizeBytes:   int64(getEnvInt("MAX_FILE_SIZE_MB", 500)) * 1024 * 1024,
-		TempDir:            getEnv("TEMP_DIR", "./tmp"),
+		Port:                   getEnv("PORT", "8080"),
+		AllowedOrigins:         splitEnv("ALLOWED_ORIGINS", []string{"*"}),
+		TurnstileSecret:        os.Getenv("TURNSTILE_SECRET_KEY"),
+		TurnstileSkip:          os.Getenv("TURNSTILE_SKIP") == "true",
+		TurnstileAction:        os.Getenv("TURNSTILE_ACTION"),
+		RateLimitPerMinute:     getEnvInt("RATE_LIMIT_RPM", 10),
+		InfoRateLimitPerMinute: getEnvInt("INFO_RATE_LIMIT_RPM", 30),
+		FileRateLimitPerMinute: getEnvInt("FILES_RATE_LIMIT_RPM", 60),
+		RateLimitBackend:       getEnv("RATE_LIMIT_BACKEND", "memory"),
+		RedisURL:               os.Getenv("REDIS_URL"),
+		R2AccountID:            os.Getenv("R2_ACCOUNT_ID"),
+		R2AccessKeyID:          os.Getenv("R2_ACCESS_KEY_ID"),
+		R2SecretAccessKey:      os.Getenv("R2_SECRET_ACCESS_KEY"),
+		R2BucketName:           getEnv("R2_BUCKET_NAME", "video-downloads"),
+		R2PublicURL:            os.Getenv("R2_PUBLIC_URL"),
+		R2StartupCheck:         os.Getenv("R2_STARTUP_CHECK") != "false",
+		R2PartSize:             int64(getEnvInt("R2_PART_SIZE_MB", 16)) * 1024 * 1024,
+		R2UploadConcurrency:    getEnvInt("R2_UPLOAD_CONCURRENCY", 4),
+		R2Endpoint:             os.Getenv("R2_ENDPOINT"),
+		PresignExpiry:          time.Duration(getEnvInt("PRESIGNED_URL_EXPIRY", 15)) * time.Minute,
+		MaxDurationSeconds:     getEnvInt("MAX_DURATION_SECONDS", 1800),
+		MaxFileSizeBytes:       int64(getEnvInt("MAX_FILE_SIZE_MB", 500)) * 1024 * 1024,
+		TempDir:                getEnv("TEMP_DIR", "./tmp"),
+		LocalBaseURL:           getEnv("LOCAL_BASE_URL", ""),
+		LocalURLSecret:         os.Getenv("LOCAL_URL_SECRET"),
+		MaxErrorLength:         getEnvInt("MAX_ERROR_LENGTH", 200),
+		SingleFlight:           os.Getenv("SINGLE_FLIGHT_DOWNLOADS") == "true",
+		DownloadMode:           getEnv("DOWNLOAD_MODE", handler.ModeAllowlist),
+		DomainPatterns:         splitEnv("ALLOWED_DOMAIN_PATTERNS", handler.DefaultDomainPatterns),
+		AllowedDomains:         splitEnv("ALLOWED_DOMAINS", nil),
+		AllowedDomainsFile:     os.Getenv("ALLOWED_DOMAINS_FILE"),
+		AllowedDomainsMode:     getEnv("ALLOWED_DOMAINS_MODE", "extend"),
+		WriteTimeout:           time.Duration(getEnvInt("WRITE_TIMEOUT_SECONDS", 600)) * time.Second,
+		MaxConnections:         getEnvInt("MAX_CONNECTIONS", 0),
+		ByteQuotaBytes:         int64(getEnvInt("BYTE_QUOTA_MB", 0)) * 1024 * 1024,
+		ByteQuotaWindow:        time.Duration(getEnvInt("BYTE_QUOTA_WINDOW_HOURS", 24)) * time.Hour,
+		SMTPHost:               os.Getenv("SMTP_HOST"),
+		SMTPPort:               getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:           os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:           os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:               os.Getenv("SMTP_FROM"),
+		NotifyMaxPerHour:       getEnvInt("NOTIFY_MAX_PER_HOUR", 5),
+		MaxConcurrentInfo:      getEnvInt("MAX_CONCURRENT_INFO", 4),
+		CodecPreference:        splitEnv("FORMAT_CODEC_PREFERENCE", downloader.DefaultCodecPreference),
+		AdminAPIKey:            os.Getenv("ADMIN_API_KEY"),
+		VideoCacheTTL:          getEnvDuration("VIDEO_CACHE_TTL", 10*time.Minute),
+		VideoCacheCleanup:      getEnvDuration("VIDEO_CACHE_CLEANUP", 5*time.Minute),
+		CacheTTLOverrides:      getEnvDurations("VIDEO_CACHE_TTL_OVERRIDES"),
+		PlatformConcurrency:    getEnvInts("PLATFORM_CONCURRENCY"),
+		CookiesFile:            os.Getenv("YTDLP_COOKIES_FILE"),
+		AllowRequestCookies:    os.Getenv("ALLOW_REQUEST_COOKIES") == "true",
+		Proxy:                  os.Getenv("YTDLP_PROXY"),
+		AllowRequestProxy:      os.Getenv("ALLOW_REQUEST_PROXY") == "true",
+		MaxDownloadRate:        os.Getenv("MAX_DOWNLOAD_RATE"),
+		APIKeys:                splitEnv("API_KEYS", nil),
+		APIKeysFile:            os.Getenv("API_KEYS_FILE"),
+		APIKeysRequired:        os.Getenv("API_KEYS_REQUIRED") == "true",
+		KeyRateLimits:          getEnvInts("API_KEY_RATE_LIMITS"),
+		KeyDailyQuota:          getEnvInt("API_KEY_DAILY_QUOTA", 0),
+		PreviewMaxSeconds:      getEnvInt("PREVIEW_MAX_SECONDS", 60),
+		PlaylistMaxItems:       getEnvInt("PLAYLIST_MAX_ITEMS", 0),
+		PlaylistUploads:        getEnvInt("PLAYLIST_UPLOAD_CONCURRENCY", 3),
+		PlaylistPartial:        os.Getenv("PLAYLIST_PARTIAL_RESULTS") == "true",
+		SponsorCategories:      splitEnv("SPONSORBLOCK_CATEGORIES", handler.DefaultSponsorCategories),
+		PreviewMaxFileSize:     int64(getEnvInt("PREVIEW_MAX_FILE_SIZE_MB", 50)) * 1024 * 1024,
+		MaxDowngrades:          getEnvInt("MAX_QUALITY_DOWNGRADES", 0),
+		MaxRetries:             getEnvInt("MAX_DOWNLOAD_RETRIES", 0),
+		MetadataOnFailure:      os.Getenv("METADATA_ON_FAILURE") == "true",
+		MaxJobDuration:         getEnvDuration("MAX_JOB_DURATION", 5*time.Minute),
 	}
 }
 
@@ -140,6 +527,46 @@ func getEnvInt(key string, fallback int) int {
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+// getEnvDurations parses a comma-separated list of name=duration pairs.
+// Malformed pairs are skipped.
+func getEnvDurations(key string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, pair := range splitEnv(key, nil) {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			durations[strings.ToLower(name)] = d
+		}
+	}
+	return durations
+}
+
+func getEnvInts(key string) map[string]int {
+	ints := make(map[string]int)
+	for _, pair := range splitEnv(key, nil) {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		var n int
+		if _, err := fmt.Sscanf(value, "%d", &n); err == nil && n > 0 {
+			ints[strings.ToLower(name)] = n
+		}
+	}
+	return ints
+}
+
 func splitEnv(key string, fallback []string) []string {
 	if v := os.Getenv(key); v != "" {
 		return strings.Split(v, ",")
@@ -0,0 +1,90 @@
+// Package cache provides an in-memory TTL cache for video metadata.
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+)
+
+// VideoCache caches resolved video info by key until its TTL expires.
+type VideoCache struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	items map[string]entry
+	stop  chan struct{}
+	once  sync.Once
+}
+
+type entry struct {
+	info    *downloader.Format
+	expires time.Time
+}
+
+// New creates a VideoCache and starts removing expired entries every
+// cleanupInterval. Call Stop to end the cleanup goroutine.
+func New(ttl, cleanupInterval time.Duration) *VideoCache {
+	c := &VideoCache{
+		ttl:   ttl,
+		items: make(map[string]entry),
+		stop:  make(chan struct{}),
+	}
+	go c.cleanup(cleanupInterval)
+	return c
+}
+
+// Get returns the cached info for key, if present and not expired.
+func (c *VideoCache) Get(key string) (*downloader.Format, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.items[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.info, true
+}
+
+// Set stores info under key for ttl, or for the cache's default TTL when
+// ttl is 0.
+func (c *VideoCache) Set(key string, info *downloader.Format, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = entry{info: info, expires: time.Now().Add(ttl)}
+}
+
+// Len returns the number of cached entries, including expired ones not yet removed.
+func (c *VideoCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// Stop ends the cleanup goroutine. It is safe to call more than once.
+func (c *VideoCache) Stop() {
+	c.once.Do(func() { close(c.stop) })
+}
+
+func (c *VideoCache) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			c.mu.Lock()
+			for key, e := range c.items {
+				if now.After(e.expires) {
+					delete(c.items, key)
+				}
+			}
+			c.mu.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// cookieArgs returns the --cookies arguments for a yt-dlp run and a cleanup
+// function that must always be called. The request's cookies, or else the
+// server's cookies file, are copied to a private temporary file: yt-dlp
+// writes the cookie jar back on exit, which must neither clobber the shared
+// file nor leave request cookies behind.
+func (d *Downloader) cookieArgs(opts Options) ([]string, func(), error) {
+	cookies := opts.Cookies
+	if len(cookies) == 0 && d.cookiesFile != "" {
+		var err error
+		if cookies, err = os.ReadFile(d.cookiesFile); err != nil {
+			return nil, func() {}, fmt.Errorf("failed to read cookies file: %w", err)
+		}
+	}
+	if len(cookies) == 0 {
+		return nil, func() {}, nil
+	}
+
+	// CreateTemp opens the file with 0600 permissions
+	file, err := os.CreateTemp(d.tempDir, ".cookies-*.txt")
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("failed to create cookies file: %w", err)
+	}
+	path := file.Name()
+	cleanup := func() { shred(path) }
+	if _, err := file.Write(cookies); err != nil {
+		file.Close()
+		cleanup()
+		return nil, func() {}, fmt.Errorf("failed to write cookies file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("failed to write cookies file: %w", err)
+	}
+	return []string{"--cookies", path}, cleanup, nil
+}
+
+// shred overwrites a file with zeros before removing it.
+func shred(path string) {
+	if info, err := os.Stat(path); err == nil {
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Write(make([]byte, info.Size()))
+			f.Sync()
+			f.Close()
+		}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove cookies file", "error", err)
+	}
+}
@@ -2,87 +2,927 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/metrics"
+	"github.com/emanuelef/yt-dl-api-go/internal/netguard"
+	"github.com/emanuelef/yt-dl-api-go/internal/redact"
+)
+
+var (
+	// ErrUpstreamRateLimited is returned when the video platform answered with HTTP 429.
+	ErrUpstreamRateLimited = errors.New("upstream platform is rate limiting requests")
+	// ErrFormatUnavailable is returned when no format matches the requested options.
+	ErrFormatUnavailable = errors.New("requested format is not available")
+	// ErrNoVideoFormats is returned when the page has no downloadable media (e.g. a text post).
+	ErrNoVideoFormats = errors.New("no video formats found at this URL")
+	// ErrFFmpegRequired is returned when an option needs ffmpeg but it is not installed.
+	ErrFFmpegRequired = errors.New("ffmpeg is required for this option but is not installed")
+	// ErrClipUnsupported is returned when the platform cannot download a section of the video.
+	ErrClipUnsupported = errors.New("this video cannot be downloaded as a clip")
+	// ErrTransient is returned for failures that may succeed on retry,
+	// such as network errors and upstream server errors.
+	ErrTransient = errors.New("transient download failure")
+	// ErrFileTooLarge is returned when the selected format exceeds the file size limit.
+	ErrFileTooLarge = errors.New("video exceeds maximum file size limit")
 )
 
+// maxRawInfoSize caps the yt-dlp info JSON returned by RawInfo.
+const maxRawInfoSize = 8 * 1024 * 1024
+
+// Config holds Downloader settings.
+type Config struct {
+	TempDir     string
+	MaxDuration int   // seconds
+	MaxFileSize int64 // bytes
+	// MaxErrorLength caps the yt-dlp detail kept in returned errors.
+	MaxErrorLength int
+	// MaxConcurrentInfo caps simultaneous info extractions (Resolve, RawInfo).
+	MaxConcurrentInfo int
+	// CodecPreference orders the formats listed by Resolve; nil uses
+	// DefaultCodecPreference.
+	CodecPreference []string
+	// CookiesFile is a Netscape cookies file passed to yt-dlp for sites that
+	// need a login; empty disables it.
+	CookiesFile string
+	// Proxy routes yt-dlp's traffic through an http, https or socks5 proxy.
+	Proxy string
+	// RateLimit caps each download's speed in bytes per second; 0 is unlimited.
+	RateLimit int64
+}
+
 // Downloader wraps yt-dlp with security constraints.
 type Downloader struct {
 	tempDir     string
 	maxDuration int
 	maxFileSize int64
+	maxErrorLen int
+	infoSlots   chan struct{}
+	hasFFmpeg   bool
+	resolver    netguard.Resolver
+	codecPrefs  []string
+	cookiesFile string
+	proxy       string
+	rateLimit   int64
+}
+
+// Subprocess metrics for yt-dlp downloads.
+var (
+	downloadDuration    = metrics.NewHistogram("ytdlp_download_seconds", 5, 15, 30, 60, 120, 300, 600)
+	downloadFirstOutput = metrics.NewHistogram("ytdlp_first_output_seconds", 0.5, 1, 2, 5, 10, 30)
+	downloadExits       = expvar.NewMap("ytdlp_download_exits")
+)
+
+// DefaultMaxHeight is the resolution cap used when Options.MaxHeight is unset.
+const DefaultMaxHeight = 1080
+
+// AllowedHeights are the accepted Options.MaxHeight values.
+var AllowedHeights = []int{360, 480, 720, 1080, 1440, 2160}
+
+// SponsorCategories are the SponsorBlock segment categories that can be removed or marked.
+var SponsorCategories = []string{"sponsor", "intro", "outro", "selfpromo", "preview", "filler", "interaction", "music_offtopic"}
+
+// IsSponsorCategory reports whether c is one of SponsorCategories.
+func IsSponsorCategory(c string) bool {
+	return slices.Contains(SponsorCategories, c)
+}
+
+// Media types for Options.Media.
+const (
+	MediaVideo = "video"
+	MediaAudio = "audio"
+)
+
+// Options holds per-request download options.
+type Options struct {
+	// Media is MediaVideo (default) or MediaAudio for audio-only extraction.
+	Media string
+	// AudioCodec is the output codec for audio extraction: mp3 (default), m4a or flac.
+	AudioCodec string
+	// FormatID pins an exact yt-dlp format (as returned by Resolve).
+	FormatID string
+	// AudioLanguage selects the audio track by language code (e.g. "en").
+	AudioLanguage string
+	// MaxHeight caps the video resolution; one of AllowedHeights, 0 means DefaultMaxHeight.
+	MaxHeight int
+	// RelaxFormat drops the mp4 preference and applies MaxHeight to the
+	// shorter side of the video, so vertical videos whose height exceeds
+	// the cap still get a format.
+	RelaxFormat bool
+	// EmbedChapters writes chapter markers into the output file (needs ffmpeg).
+	EmbedChapters bool
+	// SponsorRemove cuts these SponsorBlock categories out of the video
+	// (needs ffmpeg). Only YouTube videos have SponsorBlock data.
+	SponsorRemove []string
+	// SponsorMark marks these SponsorBlock categories as chapters instead
+	// of cutting them (needs ffmpeg).
+	SponsorMark []string
+	// ClipStart and ClipEnd, in seconds, restrict the download to that
+	// section of the video (needs ffmpeg). ClipEnd 0 means the end of the
+	// video; both 0 downloads everything.
+	ClipStart float64
+	ClipEnd   float64
+	// MaxFileSize lowers the configured file size cap for this download.
+	MaxFileSize int64
+	// Cookies is a Netscape cookies file used instead of the server's one.
+	Cookies []byte
+	// Proxy is used instead of the server's proxy.
+	Proxy string
+	// RateLimit lowers the configured download speed cap (bytes per second).
+	RateLimit int64
+	// Timeout bounds each yt-dlp download run; one that takes longer fails
+	// with a "download timed out" error. 0 leaves only ctx's deadline.
+	Timeout time.Duration
+}
+
+// clipped reports whether only a section of the video is downloaded.
+func (o Options) clipped() bool {
+	return o.ClipStart > 0 || o.ClipEnd > 0
+}
+
+// Format describes the media format yt-dlp would select for a video.
+type Format struct {
+	FormatID   string  `json:"format_id"`
+	Ext        string  `json:"ext"`
+	Resolution string  `json:"resolution,omitempty"`
+	VCodec     string  `json:"vcodec,omitempty"`
+	ACodec     string  `json:"acodec,omitempty"`
+	Filesize   int64   `json:"filesize,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+	Thumbnail  string  `json:"thumbnail,omitempty"`
+	Extractor  string  `json:"extractor,omitempty"`
+	// AudioLanguages lists the audio track languages the video offers.
+	AudioLanguages []string `json:"audio_languages,omitempty"`
+	// Chapters lists the video's chapters; empty when it has none.
+	Chapters []Chapter `json:"chapters"`
+	// Formats lists every format the video offers, best first. The ones
+	// making up the selected format are marked Recommended.
+	Formats []AvailableFormat `json:"formats,omitempty"`
+}
+
+// Chapter is one chapter of a video, with times in seconds.
+type Chapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// AvailableFormat is one of the formats a video offers.
+type AvailableFormat struct {
+	FormatID    string  `json:"format_id"`
+	Ext         string  `json:"ext"`
+	Resolution  string  `json:"resolution,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	VCodec      string  `json:"vcodec,omitempty"`
+	ACodec      string  `json:"acodec,omitempty"`
+	Bitrate     float64 `json:"bitrate,omitempty"` // kbit/s
+	Filesize    int64   `json:"filesize,omitempty"`
+	Language    string  `json:"language,omitempty"`
+	Recommended bool    `json:"recommended,omitempty"`
+}
+
+// DefaultCodecPreference orders video codecs from most to least preferred
+// when formats are otherwise equal.
+var DefaultCodecPreference = []string{"avc1", "vp9", "av01"}
+
+// Result describes a finished download.
+type Result struct {
+	FilePath string
+	Title    string
+	Duration float64
+	// Resolution, VCodec, ACodec and Bitrate (kbit/s) describe the format
+	// actually downloaded, which fallbacks may have made differ from the request.
+	Resolution string
+	VCodec     string
+	ACodec     string
+	Bitrate    float64
+	// SponsorsRemoved reports that SponsorBlock segments were cut out.
+	SponsorsRemoved bool
+	// Chapters lists the video's chapters, if it has any.
+	Chapters []Chapter
 }
 
 // New creates a new Downloader.
-func New(tempDir string, maxDuration int, maxFileSize int64) *Downloader {
-	os.MkdirAll(tempDir, 0755)
+func New(cfg Config) *Downloader {
+	os.MkdirAll(cfg.TempDir, 0755)
+	if cfg.MaxErrorLength <= 0 {
+		cfg.MaxErrorLength = 200
+	}
+	if cfg.MaxConcurrentInfo <= 0 {
+		cfg.MaxConcurrentInfo = 4
+	}
+	if cfg.CodecPreference == nil {
+		cfg.CodecPreference = DefaultCodecPreference
+	}
 	return &Downloader{
-		tempDir:     tempDir,
-		maxDuration: maxDuration,
-		maxFileSize: maxFileSize,
+		tempDir:     cfg.TempDir,
+		maxDuration: cfg.MaxDuration,
+		maxFileSize: cfg.MaxFileSize,
+		maxErrorLen: cfg.MaxErrorLength,
+		infoSlots:   make(chan struct{}, cfg.MaxConcurrentInfo),
+		hasFFmpeg:   hasBinary("ffmpeg"),
+		resolver:    net.DefaultResolver,
+		codecPrefs:  cfg.CodecPreference,
+		cookiesFile: cfg.CookiesFile,
+		proxy:       cfg.Proxy,
+		rateLimit:   cfg.RateLimit,
 	}
 }
 
-// Download downloads a video from the given URL and returns the file path.
-func (d *Downloader) Download(ctx context.Context, videoURL string) (string, error) {
+// Download downloads a video from the given URL and returns the file path
+// along with the video's title and duration.
+func (d *Downloader) Download(ctx context.Context, videoURL string, opts Options) (*Result, error) {
+	output, timestamp, err := d.run(ctx, videoURL, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract file path from output (last non-empty line)
+	filePath := extractFilePath(output, d.tempDir, timestamp)
+	if filePath == "" {
+		// yt-dlp skips oversized files and still exits 0
+		if skippedForSize(output, d.fileSizeCap(opts)) {
+			return nil, ErrFileTooLarge
+		}
+		return nil, errors.New("could not determine downloaded file path")
+	}
+
+	// Verify file exists
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, fmt.Errorf("downloaded file not found: %w", err)
+	}
+
+	result := &Result{FilePath: filePath}
+	extractMetadata(output, result, opts.SponsorRemove)
+	return result, nil
+}
+
+// run runs yt-dlp for a download and returns its output and the timestamp
+// prefixing the files it wrote, which is also set when yt-dlp failed. The
+// output of a failed run is returned too, so playlists can keep the entries
+// that finished, unless yt-dlp was stopped early. playlistItems > 0
+// downloads up to that many playlist entries instead of a single video; the
+// file size cap then applies to all of them together.
+func (d *Downloader) run(ctx context.Context, videoURL string, opts Options, playlistItems int) (string, int64, error) {
+	needsFFmpeg := opts.EmbedChapters || opts.Media == MediaAudio || opts.clipped() || len(opts.SponsorRemove) > 0 || len(opts.SponsorMark) > 0
+	if needsFFmpeg && !d.hasFFmpeg {
+		return "", 0, ErrFFmpegRequired
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if err := d.checkHost(ctx, videoURL, d.proxyFor(opts)); err != nil {
+		return "", 0, err
+	}
+
+	maxFileSize := d.fileSizeCap(opts)
+	rateLimit := d.rateLimit
+	if opts.RateLimit > 0 && (rateLimit == 0 || opts.RateLimit < rateLimit) {
+		rateLimit = opts.RateLimit
+	}
+
 	// Generate unique output filename
 	timestamp := time.Now().UnixNano()
-	outputTemplate := filepath.Join(d.tempDir, fmt.Sprintf("%d_%%(id)s.%%(ext)s", timestamp))
+	name := "%(id)s"
+	if opts.clipped() {
+		name += "_clip"
+	}
+	outputTemplate := filepath.Join(d.tempDir, fmt.Sprintf("%d_%s.%%(ext)s", timestamp, name))
+
+	cookies, cleanupCookies, err := d.cookieArgs(opts)
+	defer cleanupCookies()
+	if err != nil {
+		return "", 0, err
+	}
 
 	// Build yt-dlp arguments with security constraints
-	args := []string{
-		"--no-playlist",
-		"--max-filesize", fmt.Sprintf("%d", d.maxFileSize),
-		"--match-filter", fmt.Sprintf("duration<%d", d.maxDuration),
-		"-f", "bestvideo[height<=1080][ext=mp4]+bestaudio[ext=m4a]/best[height<=1080][ext=mp4]/best",
+	args := append(d.baseArgs(opts), playlistArgs(playlistItems)...)
+	args = append(args,
+		"--max-filesize", fmt.Sprintf("%d", maxFileSize),
 		"-o", outputTemplate,
-		"--no-cache-dir",
-		"--socket-timeout", "30",
+		"--no-overwrites",
 		"--retries", "3",
+		"--print", "video:"+expectedSizePrefix+"%(filesize,filesize_approx)s",
+		"--print", "after_move:%(.{title,duration,resolution,vcodec,acodec,tbr,chapters,sponsorblock_chapters})j",
 		"--print", "after_move:filepath",
-		videoURL,
+	)
+	if opts.Media == MediaAudio {
+		codec := opts.AudioCodec
+		if codec == "" {
+			codec = "mp3"
+		}
+		args = append(args, "-x", "--audio-format", codec)
+	}
+	if opts.EmbedChapters {
+		args = append(args, "--embed-chapters")
+	}
+	if len(opts.SponsorRemove) > 0 {
+		args = append(args, "--sponsorblock-remove", strings.Join(opts.SponsorRemove, ","))
+	}
+	if len(opts.SponsorMark) > 0 {
+		args = append(args, "--sponsorblock-mark", strings.Join(opts.SponsorMark, ","))
+	}
+	if opts.clipped() {
+		end := "inf"
+		if opts.ClipEnd > 0 {
+			end = fmt.Sprintf("%g", opts.ClipEnd)
+		}
+		args = append(args,
+			"--download-sections", fmt.Sprintf("*%g-%s", opts.ClipStart, end),
+			"--force-keyframes-at-cuts",
+		)
+	}
+	if rateLimit > 0 {
+		args = append(args, "--limit-rate", fmt.Sprintf("%d", rateLimit))
+	}
+	args = append(args, cookies...)
+	args = append(args, videoURL)
+
+	// --max-filesize only applies when the size is known upfront, so also
+	// watch the partial files and stop yt-dlp once they grow past the cap.
+	// Playlist entries count finished files too, capping their total.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watched := filepath.Join(d.tempDir, fmt.Sprintf("%d_*.part*", timestamp))
+	if playlistItems > 0 {
+		watched = filepath.Join(d.tempDir, fmt.Sprintf("%d_*", timestamp))
+	}
+	var capped atomic.Bool
+	go watchSize(runCtx, watched, maxFileSize, func() {
+		capped.Store(true)
+		cancel()
+	})
+
+	cmd := exec.CommandContext(runCtx, "yt-dlp", args...)
+	output, err := runMeasured(cmd)
+	if capped.Load() {
+		removeGlob(filepath.Join(d.tempDir, fmt.Sprintf("%d_*", timestamp)))
+		return "", 0, ErrFileTooLarge
+	}
+	if err != nil {
+		err = d.classifyError(ctx, output, videoURL)
+		if ctx.Err() != nil {
+			// Entries of a stopped run may be incomplete
+			return "", timestamp, err
+		}
+		return output, timestamp, err
+	}
+	return output, timestamp, nil
+}
+
+// fileSizeCap returns the file size cap for a download with opts.
+func (d *Downloader) fileSizeCap(opts Options) int64 {
+	if opts.MaxFileSize > 0 && opts.MaxFileSize < d.maxFileSize {
+		return opts.MaxFileSize
+	}
+	return d.maxFileSize
+}
+
+// expectedSizePrefix starts the line yt-dlp prints with the selected
+// format's size, known or estimated, before downloading it.
+const expectedSizePrefix = "expected_size="
+
+// skippedForSize reports whether yt-dlp skipped a download for exceeding
+// limit. Its own "larger than max-filesize" notice is hidden by the quiet
+// mode --print implies, so the printed sizes are compared instead.
+func skippedForSize(output string, limit int64) bool {
+	for _, line := range strings.Split(output, "\n") {
+		size, ok := strings.CutPrefix(strings.TrimSpace(line), expectedSizePrefix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseFloat(size, 64); err == nil && n > float64(limit) {
+			return true
+		}
+	}
+	return strings.Contains(output, "larger than max-filesize")
+}
+
+// sizeCheckInterval is how often watchSize sums the partial files.
+const sizeCheckInterval = time.Second
+
+// watchSize calls exceeded once the files matching pattern together grow
+// past limit, and returns when ctx is done.
+func watchSize(ctx context.Context, pattern string, limit int64, exceeded func()) {
+	ticker := time.NewTicker(sizeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		matches, _ := filepath.Glob(pattern)
+		var total int64
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil {
+				total += info.Size()
+			}
+		}
+		if total > limit {
+			slog.Warn("Download exceeded byte cap, stopping yt-dlp", "bytes", total, "limit", limit)
+			exceeded()
+			return
+		}
+	}
+}
+
+// removeGlob deletes every file matching pattern.
+func removeGlob(pattern string) {
+	matches, _ := filepath.Glob(pattern)
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
+
+// runMeasured runs a yt-dlp download and returns its combined output,
+// recording wall time, time to first output and exit status even on failure.
+func runMeasured(cmd *exec.Cmd) (string, error) {
+	start := time.Now()
+	out := &firstWriteBuffer{onFirst: func() {
+		downloadFirstOutput.Observe(time.Since(start).Seconds())
+	}}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err := cmd.Run()
+	downloadDuration.Observe(time.Since(start).Seconds())
+
+	switch ps := cmd.ProcessState; {
+	case ps == nil:
+		downloadExits.Add("start_error", 1)
+	case ps.ExitCode() == -1:
+		downloadExits.Add("signal", 1)
+	default:
+		downloadExits.Add(fmt.Sprintf("exit_%d", ps.ExitCode()), 1)
+	}
+
+	return out.String(), err
+}
+
+// firstWriteBuffer buffers output and calls onFirst on its first write.
+// The buffer is a named field rather than embedded: an embedded
+// bytes.Buffer would promote ReadFrom, which exec's io.Copy prefers over
+// Write, so onFirst would never run.
+type firstWriteBuffer struct {
+	buf     bytes.Buffer
+	onFirst func()
+	seen    bool
+}
+
+func (b *firstWriteBuffer) Write(p []byte) (int, error) {
+	if !b.seen {
+		b.seen = true
+		b.onFirst()
+	}
+	return b.buf.Write(p)
+}
+
+func (b *firstWriteBuffer) String() string {
+	return b.buf.String()
+}
+
+// Check verifies that yt-dlp can be run.
+func (d *Downloader) Check(ctx context.Context) error {
+	if err := exec.CommandContext(ctx, "yt-dlp", "--version").Run(); err != nil {
+		return fmt.Errorf("yt-dlp is not runnable: %w", err)
+	}
+	return nil
+}
+
+// Resolve extracts video info and returns the exact format Download would
+// fetch with the same options, without downloading anything.
+func (d *Downloader) Resolve(ctx context.Context, videoURL string, opts Options) (*Format, error) {
+	if err := d.checkHost(ctx, videoURL, d.proxyFor(opts)); err != nil {
+		return nil, err
+	}
+	release, err := d.acquireInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	cookies, cleanupCookies, err := d.cookieArgs(opts)
+	defer cleanupCookies()
+	if err != nil {
+		return nil, err
+	}
+	args := append(d.baseArgs(opts), cookies...)
+	args = append(args, "--dump-json", videoURL)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, d.classifyError(ctx, stderr.String(), videoURL)
+	}
+
+	// Videos rejected by --match-filter are skipped without a non-zero exit
+	if strings.TrimSpace(stdout.String()) == "" {
+		return nil, d.classifyError(ctx, stderr.String(), videoURL)
+	}
+
+	format, err := parseFormat(stdout.Bytes(), videoURL)
+	if err != nil {
+		return nil, err
+	}
+	sortFormats(format.Formats, d.codecPrefs)
+	return format, nil
+}
+
+// RawInfo returns yt-dlp's complete info JSON for a video, unmodified.
+func (d *Downloader) RawInfo(ctx context.Context, videoURL string) (json.RawMessage, error) {
+	if err := d.checkHost(ctx, videoURL, d.proxy); err != nil {
+		return nil, err
+	}
+	release, err := d.acquireInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	cookies, cleanupCookies, err := d.cookieArgs(Options{})
+	defer cleanupCookies()
+	if err != nil {
+		return nil, err
 	}
+	args := append(d.baseArgs(Options{}), cookies...)
+	args = append(args, "--dump-json", videoURL)
 
+	stdout := &limitedBuffer{max: maxRawInfoSize}
+	var stderr bytes.Buffer
 	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
-	output, err := cmd.CombinedOutput()
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, d.classifyError(ctx, stderr.String(), videoURL)
+	}
+	if stdout.overflow {
+		return nil, errors.New("video info exceeds size limit")
+	}
+	if strings.TrimSpace(stdout.String()) == "" {
+		return nil, d.classifyError(ctx, stderr.String(), videoURL)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(&stdout.Buffer).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse video info: %w", err)
+	}
+	return raw, nil
+}
+
+// checkHost resolves the URL's host and rejects it if any address is
+// private or internal, before yt-dlp is started. yt-dlp resolves the host
+// again itself, so this narrows DNS rebinding rather than ruling it out.
+// Behind a proxy the proxy resolves the host, so a failed local lookup is
+// not an error; hosts that do resolve to forbidden addresses still are.
+func (d *Downloader) checkHost(ctx context.Context, videoURL, proxy string) error {
+	parsed, err := url.Parse(videoURL)
 	if err != nil {
-		outputStr := string(output)
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	err = netguard.CheckHost(ctx, d.resolver, parsed.Hostname())
+	if err != nil && proxy != "" && !errors.Is(err, netguard.ErrForbiddenAddress) {
+		slog.Debug("Host not resolvable locally, leaving it to the proxy", "host", parsed.Hostname(), "error", err)
+		return nil
+	}
+	return err
+}
+
+// proxyFor returns the proxy yt-dlp uses for opts: the request's own or
+// the server's.
+func (d *Downloader) proxyFor(opts Options) string {
+	if opts.Proxy != "" {
+		return opts.Proxy
+	}
+	return d.proxy
+}
+
+// acquireInfo waits for a free info extraction slot.
+func (d *Downloader) acquireInfo(ctx context.Context) (release func(), err error) {
+	select {
+	case d.infoSlots <- struct{}{}:
+		return func() { <-d.infoSlots }, nil
+	case <-ctx.Done():
+		return nil, errors.New("timed out waiting for an info slot")
+	}
+}
+
+// limitedBuffer buffers up to max bytes and silently drops the rest,
+// recording the overflow. Writes never fail so yt-dlp is not blocked.
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
 
-		// Check for specific error conditions
-		if strings.Contains(outputStr, "Video unavailable") {
-			return "", errors.New("video is unavailable or private")
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || b.Len()+len(p) > b.max {
+		b.overflow = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// videoInfo is the subset of yt-dlp's info JSON used by the downloader.
+type videoInfo struct {
+	Format
+	FilesizeApprox int64  `json:"filesize_approx"`
+	OriginalURL    string `json:"original_url"`
+	Formats        []struct {
+		AvailableFormat
+		TBR            float64 `json:"tbr"`
+		FilesizeApprox int64   `json:"filesize_approx"`
+	} `json:"formats"`
+}
+
+// parseFormat picks the info object for videoURL out of yt-dlp's JSON
+// output. yt-dlp can emit several objects; the one whose original_url
+// matches is preferred, otherwise the first object carrying a format.
+func parseFormat(data []byte, videoURL string) (*Format, error) {
+	var chosen *videoInfo
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var info videoInfo
+		if err := dec.Decode(&info); err == io.EOF {
+			break
+		} else if err != nil {
+			if chosen != nil {
+				break // keep what was parsed before the malformed tail
+			}
+			return nil, fmt.Errorf("failed to parse video info: %w", err)
 		}
-		if strings.Contains(outputStr, "duration<") && strings.Contains(outputStr, "skipping") {
-			return "", errors.New("video exceeds maximum duration limit")
+		if info.FormatID == "" {
+			continue
 		}
-		if strings.Contains(outputStr, "filesize") {
-			return "", errors.New("video exceeds maximum file size limit")
+		if info.OriginalURL == videoURL {
+			chosen = &info
+			break
 		}
-		if ctx.Err() == context.DeadlineExceeded {
-			return "", errors.New("download timed out")
+		if chosen == nil {
+			chosen = &info
 		}
+	}
+	if chosen == nil {
+		return nil, errors.New("no video info in yt-dlp output")
+	}
 
-		return "", fmt.Errorf("yt-dlp error: %s", truncate(outputStr, 200))
+	if chosen.Filesize == 0 {
+		chosen.Filesize = chosen.FilesizeApprox
+	}
+	if chosen.Chapters == nil {
+		chosen.Chapters = []Chapter{}
+	}
+	seen := make(map[string]bool)
+	selected := strings.Split(chosen.FormatID, "+")
+	for _, f := range chosen.Formats {
+		if f.Language != "" && f.ACodec != "none" && !seen[f.Language] {
+			seen[f.Language] = true
+			chosen.AudioLanguages = append(chosen.AudioLanguages, f.Language)
+		}
+		if f.VCodec == "none" && f.ACodec == "none" {
+			continue // Storyboards and other non-media entries
+		}
+		af := f.AvailableFormat
+		af.Bitrate = f.TBR
+		if af.Filesize == 0 {
+			af.Filesize = f.FilesizeApprox
+		}
+		af.Recommended = slices.Contains(selected, af.FormatID)
+		chosen.Format.Formats = append(chosen.Format.Formats, af)
 	}
+	return &chosen.Format, nil
+}
 
-	// Extract file path from output (last non-empty line)
-	filePath := extractFilePath(string(output), d.tempDir, timestamp)
-	if filePath == "" {
-		return "", errors.New("could not determine downloaded file path")
+// sortFormats orders formats by height, then bitrate, then the position of
+// their video codec in prefs, best first.
+func sortFormats(formats []AvailableFormat, prefs []string) {
+	rank := func(vcodec string) int {
+		for i, p := range prefs {
+			if strings.HasPrefix(vcodec, p) {
+				return i
+			}
+		}
+		return len(prefs)
 	}
+	slices.SortStableFunc(formats, func(a, b AvailableFormat) int {
+		if a.Height != b.Height {
+			return b.Height - a.Height
+		}
+		if a.Bitrate != b.Bitrate {
+			if a.Bitrate > b.Bitrate {
+				return -1
+			}
+			return 1
+		}
+		return rank(a.VCodec) - rank(b.VCodec)
+	})
+}
 
-	// Verify file exists
-	if _, err := os.Stat(filePath); err != nil {
-		return "", fmt.Errorf("downloaded file not found: %w", err)
+// baseArgs returns the yt-dlp arguments shared by Download and Resolve, so
+// both always agree on which format gets selected.
+func (d *Downloader) baseArgs(opts Options) []string {
+	args := []string{
+		"--no-playlist",
+		"--match-filter", fmt.Sprintf("duration<%d", d.maxDuration),
+		"-f", formatSelector(opts),
+		"--no-cache-dir",
+		"--socket-timeout", "30",
+	}
+	if relaxed(opts) {
+		// yt-dlp's "res" is the smaller dimension, so vertical videos are
+		// capped by their width
+		args = append(args, "-S", fmt.Sprintf("res:%d", maxHeight(opts)))
+	}
+	if proxy := d.proxyFor(opts); proxy != "" {
+		args = append(args, "--proxy", proxy)
+	}
+	return args
+}
+
+// ratePattern matches a download rate: a number with an optional K or M suffix.
+var ratePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)([KkMm]?)$`)
+
+// ParseRate parses a download rate such as "500K" or "2M" into bytes per
+// second. K and M are binary multiples, as in yt-dlp.
+func ParseRate(s string) (int64, error) {
+	m := ratePattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid rate %q: want a number with an optional K or M suffix", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	switch strings.ToUpper(m[2]) {
+	case "K":
+		n *= 1 << 10
+	case "M":
+		n *= 1 << 20
+	}
+	if n < 1 || n > 1<<40 {
+		return 0, fmt.Errorf("rate %q is out of range", s)
+	}
+	return int64(n), nil
+}
+
+// proxySchemes are the proxy URL schemes yt-dlp supports.
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// ValidateProxy checks that rawURL is a proxy URL yt-dlp can use.
+func ValidateProxy(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !proxySchemes[parsed.Scheme] || parsed.Hostname() == "" {
+		return errors.New("proxy must be an http, https, socks5 or socks5h URL")
+	}
+	if strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return errors.New("proxy URL must not have a path or query")
+	}
+	return nil
+}
+
+// formatSelector returns the yt-dlp -f expression for the given options.
+func formatSelector(opts Options) string {
+	if opts.FormatID != "" {
+		return opts.FormatID
 	}
+	if opts.Media == MediaAudio {
+		if opts.AudioLanguage != "" {
+			return fmt.Sprintf("bestaudio[language^=%s]", opts.AudioLanguage)
+		}
+		return "bestaudio/best"
+	}
+
+	lang := ""
+	if opts.AudioLanguage != "" {
+		lang = fmt.Sprintf("[language^=%s]", opts.AudioLanguage)
+	}
+	if opts.RelaxFormat {
+		// The height cap is applied through -S res, see baseArgs
+		if lang != "" {
+			return "bestvideo+bestaudio" + lang
+		}
+		return "bestvideo+bestaudio/best"
+	}
+
+	h := fmt.Sprintf("[height<=%d]", maxHeight(opts))
+	if lang != "" {
+		// No fallback to other languages: a missing track must fail visibly
+		return "bestvideo" + h + "[ext=mp4]+bestaudio[ext=m4a]" + lang +
+			"/bestvideo" + h + "+bestaudio" + lang
+	}
+	return "bestvideo" + h + "[ext=mp4]+bestaudio[ext=m4a]/best" + h + "[ext=mp4]/best"
+}
+
+// relaxed reports whether formatSelector uses the relaxed video selector.
+func relaxed(opts Options) bool {
+	return opts.RelaxFormat && opts.FormatID == "" && opts.Media != MediaAudio
+}
+
+// maxHeight returns the height cap for opts.
+func maxHeight(opts Options) int {
+	if opts.MaxHeight == 0 {
+		return DefaultMaxHeight
+	}
+	return opts.MaxHeight
+}
+
+// LowerHeight returns the next allowed height below height, or 0 if there is none.
+func LowerHeight(height int) int {
+	lower := 0
+	for _, h := range AllowedHeights {
+		if h < height && h > lower {
+			lower = h
+		}
+	}
+	return lower
+}
 
-	return filePath, nil
+// IsAllowedHeight reports whether height is an accepted Options.MaxHeight.
+func IsAllowedHeight(height int) bool {
+	return slices.Contains(AllowedHeights, height)
+}
+
+// classifyError maps yt-dlp output to a user-facing error.
+func (d *Downloader) classifyError(ctx context.Context, output, videoURL string) error {
+	// Check for specific error conditions
+	if isRateLimited(output) {
+		return ErrUpstreamRateLimited
+	}
+	if strings.Contains(output, "No video formats found") {
+		return ErrNoVideoFormats
+	}
+	if strings.Contains(output, "Video unavailable") {
+		return errors.New("video is unavailable or private")
+	}
+	if strings.Contains(output, "Requested format is not available") {
+		return ErrFormatUnavailable
+	}
+	if strings.Contains(output, "cannot be partially downloaded") || strings.Contains(output, "download-sections") {
+		return ErrClipUnsupported
+	}
+	if strings.Contains(output, "duration<") && strings.Contains(output, "skipping") {
+		return errors.New("video exceeds maximum duration limit")
+	}
+	if strings.Contains(output, "filesize") {
+		return ErrFileTooLarge
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New("download timed out")
+	}
+	if isTransient(output) {
+		return fmt.Errorf("%w: %s", ErrTransient, d.sanitizeOutput(output))
+	}
+
+	// yt-dlp may echo the URL back; keep any tokens in it out of logs
+	output = strings.ReplaceAll(output, videoURL, redact.URL(videoURL))
+	slog.Debug("yt-dlp failed", "output", output)
+	return fmt.Errorf("yt-dlp error: %s", d.sanitizeOutput(output))
+}
+
+// sanitizeOutput reduces yt-dlp output to its ERROR lines, with local
+// paths removed and the length capped.
+func (d *Downloader) sanitizeOutput(output string) string {
+	var errLines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "ERROR:") {
+			errLines = append(errLines, line)
+		}
+	}
+	msg := strings.TrimSpace(output)
+	if len(errLines) > 0 {
+		msg = strings.Join(errLines, " ")
+	}
+
+	if abs, err := filepath.Abs(d.tempDir); err == nil {
+		msg = strings.ReplaceAll(msg, abs, "<tmp>")
+	}
+	msg = strings.ReplaceAll(msg, d.tempDir, "<tmp>")
+
+	return truncate(msg, d.maxErrorLen)
 }
 
 // extractFilePath finds the downloaded file path from yt-dlp output.
@@ -99,14 +939,115 @@ func extractFilePath(output, tempDir string, timestamp int64) string {
 		}
 	}
 
-	// Fallback: find by pattern
+	// Fallback for post-processors that move the file without the print
+	// firing: the newest finished file of this download
 	pattern := filepath.Join(tempDir, fmt.Sprintf("%d_*", timestamp))
 	matches, _ := filepath.Glob(pattern)
-	if len(matches) > 0 {
-		return matches[0]
+	var newest string
+	var newestTime time.Time
+	for _, m := range matches {
+		if isPartial(m) {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest, newestTime = m, info.ModTime()
+		}
 	}
+	return newest
+}
+
+// isPartial reports whether path is one of yt-dlp's in-progress files.
+func isPartial(path string) bool {
+	for _, ext := range []string{".part", ".ytdl", ".temp"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return strings.Contains(filepath.Base(path), ".part-Frag")
+}
+
+// extractMetadata fills the metadata fields of result from the JSON line
+// printed after the move. Missing metadata is not an error.
+func extractMetadata(output string, result *Result, remove []string) {
+	for _, line := range strings.Split(output, "\n") {
+		if parseMetadata(strings.TrimSpace(line), result, remove) {
+			return
+		}
+	}
+}
+
+// parseMetadata fills the metadata fields of result from one printed JSON
+// line, reporting whether line was one. SponsorBlock segments of the
+// remove categories mark the result as having sponsors removed.
+func parseMetadata(line string, result *Result, remove []string) bool {
+	if !strings.HasPrefix(line, "{") {
+		return false
+	}
+	var meta struct {
+		Title      string    `json:"title"`
+		Duration   float64   `json:"duration"`
+		Resolution string    `json:"resolution"`
+		VCodec     string    `json:"vcodec"`
+		ACodec     string    `json:"acodec"`
+		TBR        float64   `json:"tbr"`
+		Chapters   []Chapter `json:"chapters"`
+		Sponsors   []struct {
+			Category string `json:"category"`
+		} `json:"sponsorblock_chapters"`
+	}
+	if json.Unmarshal([]byte(line), &meta) != nil {
+		return false
+	}
+	result.Title = meta.Title
+	result.Duration = meta.Duration
+	result.Resolution = meta.Resolution
+	result.VCodec = meta.VCodec
+	result.ACodec = meta.ACodec
+	result.Bitrate = meta.TBR
+	result.Chapters = meta.Chapters
+	for _, s := range meta.Sponsors {
+		if slices.Contains(remove, s.Category) {
+			result.SponsorsRemoved = true
+		}
+	}
+	return true
+}
+
+// transientPatterns are yt-dlp output fragments of failures worth retrying.
+var transientPatterns = []string{
+	"HTTP Error 500", "HTTP Error 502", "HTTP Error 503", "HTTP Error 504",
+	"Temporary failure", "timed out", "Connection reset", "Connection refused",
+	"Remote end closed connection",
+}
+
+// isTransient reports whether yt-dlp output shows a failure worth retrying.
+func isTransient(output string) bool {
+	for _, p := range transientPatterns {
+		if strings.Contains(output, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryable reports whether a Download error may succeed on retry.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrTransient) || errors.Is(err, ErrUpstreamRateLimited)
+}
+
+// isRateLimited reports whether yt-dlp output contains an HTTP 429 from the platform.
+func isRateLimited(output string) bool {
+	return strings.Contains(output, "HTTP Error 429") || strings.Contains(output, "Too Many Requests")
+}
 
-	return ""
+// hasBinary reports whether name is available on PATH.
+func hasBinary(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
 }
 
 // truncate shortens a string for error messages.
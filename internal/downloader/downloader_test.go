@@ -0,0 +1,656 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/netguard"
+)
+
+// testURL is an IP literal, so downloads in tests skip DNS.
+const testURL = "https://93.184.216.34/watch?v=abc"
+
+// installYTDLP puts a yt-dlp shell script with body on PATH.
+func installYTDLP(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "yt-dlp"), []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// fakeYTDLP puts a yt-dlp on PATH that prints output and exits 0. Each
+// "FILE:<id>" line of output is replaced by the path of a file it creates
+// from the -o template, as --print after_move:filepath would print it. The
+// file holds the script's PID; with --no-overwrites an existing file is kept.
+// Playlist listings (--flat-playlist) print nothing.
+func fakeYTDLP(t *testing.T, output string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out")
+	if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	installYTDLP(t, `while [ $# -gt 0 ]; do
+	[ "$1" = -o ] && tmpl=$2
+	[ "$1" = --no-overwrites ] && keep=1
+	[ "$1" = --flat-playlist ] && exit 0
+	shift
+done
+while IFS= read -r line; do
+	case $line in
+	FILE:*)
+		f=$(printf '%s' "$tmpl" | sed "s/%(id)s/${line#FILE:}/; s/%(ext)s/mp4/")
+		[ -n "$keep" ] && [ -e "$f" ] || echo $$ > "$f"
+		echo "$f" ;;
+	*) printf '%s\n' "$line" ;;
+	esac
+done < '`+path+"'\n")
+}
+
+// failingYTDLP puts a yt-dlp on PATH that writes stderr and exits 1.
+func failingYTDLP(t *testing.T, stderr string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stderr")
+	if err := os.WriteFile(path, []byte(stderr), 0644); err != nil {
+		t.Fatal(err)
+	}
+	installYTDLP(t, "cat '"+path+"' >&2\nexit 1\n")
+}
+
+func TestDownloadRateLimited(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   bool
+	}{
+		{"http 429", "[youtube] abc: Downloading webpage\nERROR: [youtube] abc: Unable to download webpage: HTTP Error 429: Too Many Requests (caused by <HTTPError 429: Too Many Requests>)\n", true},
+		{"fragment 429", "[download] Got error: HTTP Error 429: Too Many Requests. Retrying fragment 12 (1/3)...\nERROR: fragment 12 not found, unable to continue\n", true},
+		{"reason only", "ERROR: [instagram] abc: Too Many Requests\n", true},
+		{"unavailable", "ERROR: [youtube] abc: Video unavailable\n", false},
+		{"server error", "ERROR: unable to download video data: HTTP Error 503: Service Unavailable\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failingYTDLP(t, tt.stderr)
+			d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+			_, err := d.Download(context.Background(), testURL, Options{})
+			if err == nil {
+				t.Fatal("Download succeeded")
+			}
+			if got := errors.Is(err, ErrUpstreamRateLimited); got != tt.want {
+				t.Errorf("Download error = %v, want ErrUpstreamRateLimited %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentDownloadsKeepSeparateFiles(t *testing.T) {
+	fakeYTDLP(t, "FILE:abc\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	var wg sync.WaitGroup
+	results := make([]*Result, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = d.Download(context.Background(), testURL, Options{})
+		}()
+	}
+	wg.Wait()
+
+	var paths []string
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, results[i].FilePath)
+	}
+	if paths[0] == paths[1] {
+		t.Fatalf("both downloads wrote %s", paths[0])
+	}
+	var contents []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	if contents[0] == contents[1] {
+		t.Errorf("both files hold %q", contents[0])
+	}
+}
+
+func TestDownloadErrorDetails(t *testing.T) {
+	tempDir := t.TempDir()
+	var stderr strings.Builder
+	for i := range 50 {
+		fmt.Fprintf(&stderr, "[download] %.1f%% of 12.34MiB at 1.2MiB/s ETA 00:10\n", float64(i)*2)
+	}
+	fmt.Fprintf(&stderr, "WARNING: [youtube] abc: nsig extraction failed: You may experience throttling\n")
+	fmt.Fprintf(&stderr, "ERROR: unable to write data to %s/1700000000_abc.f137.mp4.part: [Errno 28] No space left on device\n", tempDir)
+	fmt.Fprintf(&stderr, "ERROR: %s\n", strings.Repeat("x", 500))
+	failingYTDLP(t, stderr.String())
+	d := New(Config{TempDir: tempDir, MaxDuration: 3600, MaxFileSize: 1 << 20, MaxErrorLength: 120})
+
+	_, err := d.Download(context.Background(), testURL, Options{})
+	if err == nil {
+		t.Fatal("Download succeeded")
+	}
+	msg := err.Error()
+	detail := strings.TrimPrefix(msg, "yt-dlp error: ")
+	if len(detail) > 120+len("...") {
+		t.Errorf("detail is %d bytes, want at most 120 plus ellipsis", len(detail))
+	}
+	if !strings.HasPrefix(detail, "ERROR: unable to write data to <tmp>/1700000000_abc") {
+		t.Errorf("detail = %q, want the first ERROR line with the temp dir masked", detail)
+	}
+	if strings.Contains(msg, tempDir) || strings.Contains(msg, "WARNING") || strings.Contains(msg, "[download]") {
+		t.Errorf("error leaks output details: %q", msg)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	const videoURL = "https://www.youtube.com/watch?v=abc"
+	tests := []struct {
+		name     string
+		output   string
+		wantID   string
+		wantSize int64
+	}{
+		{
+			"single object",
+			`{"format_id": "22", "ext": "mp4", "title": "Only", "filesize": 1000}`,
+			"22", 1000,
+		},
+		{
+			"matching original_url wins",
+			`{"format_id": "18", "ext": "mp4", "title": "Other", "original_url": "https://www.youtube.com/watch?v=xyz"}
+{"format_id": "137+140", "ext": "mp4", "title": "Wanted", "original_url": "https://www.youtube.com/watch?v=abc", "filesize_approx": 5000}
+{"format_id": "251", "ext": "webm", "title": "Later"}`,
+			"137+140", 5000,
+		},
+		{
+			"first with a format",
+			`{"_type": "playlist", "title": "Channel"}
+{"format_id": "22", "ext": "mp4", "title": "First", "original_url": "https://youtu.be/other"}
+{"format_id": "18", "ext": "mp4", "title": "Second", "original_url": "https://youtu.be/another"}`,
+			"22", 0,
+		},
+		{
+			"malformed tail",
+			`{"format_id": "22", "ext": "mp4", "title": "Complete", "original_url": "https://youtu.be/x"}
+{"format_id": "18", "ext": "mp4", "tit`,
+			"22", 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := parseFormat([]byte(tt.output), videoURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format.FormatID != tt.wantID || format.Filesize != tt.wantSize {
+				t.Errorf("format = %+v, want ID %s, size %d", format, tt.wantID, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestParseFormatNoInfo(t *testing.T) {
+	for _, output := range []string{"", `{"_type": "playlist"}`, "not json"} {
+		if _, err := parseFormat([]byte(output), "https://youtu.be/abc"); err == nil {
+			t.Errorf("parseFormat(%q) succeeded", output)
+		}
+	}
+}
+
+func TestRawInfoRoundTrip(t *testing.T) {
+	const info = `{"id": "abc", "title": "Café 🎬", "duration": 1.5e2, "extractor_key": "Youtube", "formats": [{"format_id": "18", "ext": "mp4", "fragments": null}, {"format_id": "22", "http_headers": {"User-Agent": "Mozilla/5.0"}}], "_version": {"version": "2025.01.15"}}`
+	fakeYTDLP(t, info+"\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	raw, err := d.RawInfo(context.Background(), testURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != info {
+		t.Errorf("RawInfo =\n %s\nwant\n %s", raw, info)
+	}
+}
+
+func TestFormatSelector(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default", Options{}, "bestvideo[height<=1080][ext=mp4]+bestaudio[ext=m4a]/best[height<=1080][ext=mp4]/best"},
+		{"max height", Options{MaxHeight: 480}, "bestvideo[height<=480][ext=mp4]+bestaudio[ext=m4a]/best[height<=480][ext=mp4]/best"},
+		{"pinned", Options{FormatID: "137+140"}, "137+140"},
+		{"pinned wins over language", Options{FormatID: "22", AudioLanguage: "de"}, "22"},
+		{"audio track", Options{AudioLanguage: "pt-BR"}, "bestvideo[height<=1080][ext=mp4]+bestaudio[ext=m4a][language^=pt-BR]/bestvideo[height<=1080]+bestaudio[language^=pt-BR]"},
+		{"audio track at 720p", Options{AudioLanguage: "en", MaxHeight: 720}, "bestvideo[height<=720][ext=mp4]+bestaudio[ext=m4a][language^=en]/bestvideo[height<=720]+bestaudio[language^=en]"},
+		{"audio only", Options{Media: MediaAudio}, "bestaudio/best"},
+		{"audio only track", Options{Media: MediaAudio, AudioLanguage: "ja"}, "bestaudio[language^=ja]"},
+		{"relaxed", Options{RelaxFormat: true, MaxHeight: 720}, "bestvideo+bestaudio/best"},
+		{"relaxed audio track", Options{RelaxFormat: true, AudioLanguage: "en"}, "bestvideo+bestaudio[language^=en]"},
+	}
+	for _, tt := range tests {
+		if got := formatSelector(tt.opts); got != tt.want {
+			t.Errorf("%s: formatSelector = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseFormatAudioLanguages(t *testing.T) {
+	output := `{"format_id": "137+140-1", "ext": "mp4", "formats": [
+		{"format_id": "140-0", "acodec": "mp4a.40.2", "language": "en"},
+		{"format_id": "140-1", "acodec": "mp4a.40.2", "language": "es"},
+		{"format_id": "251-1", "acodec": "opus", "language": "es"},
+		{"format_id": "137", "acodec": "none", "language": "fr"},
+		{"format_id": "sb0", "acodec": "none"}
+	]}`
+	format, err := parseFormat([]byte(output), "https://youtu.be/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(format.AudioLanguages, ","); got != "en,es" {
+		t.Errorf("AudioLanguages = %s, want en,es", got)
+	}
+}
+
+// histogramCount reads the observation count of a published histogram.
+func histogramCount(t *testing.T, name string) uint64 {
+	t.Helper()
+	var h struct {
+		Count uint64 `json:"count"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &h); err != nil {
+		t.Fatal(err)
+	}
+	return h.Count
+}
+
+// exitCount reads a counter of the ytdlp_download_exits map.
+func exitCount(key string) int64 {
+	if v, ok := downloadExits.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestDownloadMetrics(t *testing.T) {
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+	runs := histogramCount(t, "ytdlp_download_seconds")
+	firsts := histogramCount(t, "ytdlp_first_output_seconds")
+	ok, failed := exitCount("exit_0"), exitCount("exit_1")
+
+	fakeYTDLP(t, "FILE:abc\n")
+	if _, err := d.Download(context.Background(), testURL, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	failingYTDLP(t, "ERROR: [youtube] abc: Video unavailable\n")
+	if _, err := d.Download(context.Background(), testURL, Options{}); err == nil {
+		t.Fatal("Download succeeded")
+	}
+
+	if got := histogramCount(t, "ytdlp_download_seconds") - runs; got != 2 {
+		t.Errorf("ytdlp_download_seconds observations = %d, want 2", got)
+	}
+	if got := histogramCount(t, "ytdlp_first_output_seconds") - firsts; got != 2 {
+		t.Errorf("ytdlp_first_output_seconds observations = %d, want 2", got)
+	}
+	if got := exitCount("exit_0") - ok; got != 1 {
+		t.Errorf("exit_0 = +%d, want +1", got)
+	}
+	if got := exitCount("exit_1") - failed; got != 1 {
+		t.Errorf("exit_1 = +%d, want +1", got)
+	}
+}
+
+// stubResolver answers lookups from a fixed table.
+type stubResolver map[string]string
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func TestCheckHostBeforeYTDLP(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	installYTDLP(t, "touch '"+marker+"'\nexit 1\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+	d.resolver = stubResolver{"private.test": "10.0.0.1", "public.test": "93.184.216.34"}
+
+	for _, host := range []string{"private.test", "127.0.0.1", "[::1]"} {
+		videoURL := "https://" + host + "/watch?v=abc"
+		_, err := d.Download(context.Background(), videoURL, Options{})
+		if !errors.Is(err, netguard.ErrForbiddenAddress) {
+			t.Errorf("Download(%s) error = %v, want ErrForbiddenAddress", host, err)
+		}
+		if _, err := d.Resolve(context.Background(), videoURL, Options{}); !errors.Is(err, netguard.ErrForbiddenAddress) {
+			t.Errorf("Resolve(%s) error = %v, want ErrForbiddenAddress", host, err)
+		}
+		if _, err := d.RawInfo(context.Background(), videoURL); !errors.Is(err, netguard.ErrForbiddenAddress) {
+			t.Errorf("RawInfo(%s) error = %v, want ErrForbiddenAddress", host, err)
+		}
+	}
+	if _, err := d.Download(context.Background(), "https://missing.test/v", Options{}); err == nil {
+		t.Error("Download of an unresolvable host succeeded")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("yt-dlp ran for a rejected host")
+	}
+
+	// A public host gets through to yt-dlp
+	d.Download(context.Background(), "https://public.test/watch?v=abc", Options{})
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("yt-dlp did not run for a public host")
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	installYTDLP(t, "exec sleep 5\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	start := time.Now()
+	_, err := d.Download(context.Background(), testURL, Options{Timeout: 100 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Download error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Download took %v, want it stopped at the timeout", elapsed)
+	}
+}
+
+func TestCheckHostWithProxy(t *testing.T) {
+	resolver := stubResolver{"private.test": "10.0.0.1"}
+	tests := []struct {
+		name        string
+		serverProxy string
+		opts        Options
+		host        string
+		wantErr     bool
+	}{
+		{"no proxy, unresolvable", "", Options{}, "missing.test", true},
+		{"server proxy, unresolvable", "http://proxy.test:8080", Options{}, "missing.test", false},
+		{"request proxy, unresolvable", "", Options{Proxy: "socks5://proxy.test:1080"}, "missing.test", false},
+		{"server proxy, private", "http://proxy.test:8080", Options{}, "private.test", true},
+		{"server proxy, loopback", "http://proxy.test:8080", Options{}, "127.0.0.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeYTDLP(t, "FILE:abc\n")
+			d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20, Proxy: tt.serverProxy})
+			d.resolver = resolver
+
+			_, err := d.Download(context.Background(), "https://"+tt.host+"/watch?v=abc", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Download error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// verticalYTDLP puts a yt-dlp on PATH that behaves like a vertical video:
+// no format passes the height filter unless the cap is applied with -S.
+func verticalYTDLP(t *testing.T) {
+	t.Helper()
+	installYTDLP(t, `while [ $# -gt 0 ]; do
+	[ "$1" = -o ] && tmpl=$2
+	[ "$1" = -S ] && sort=$2
+	shift
+done
+if [ -z "$sort" ]; then
+	echo "ERROR: [tiktok] abc: Requested format is not available" >&2
+	exit 1
+fi
+f=$(printf '%s' "$tmpl" | sed "s/%(id)s/abc/; s/%(ext)s/mp4/")
+echo "$sort" > "$f"
+echo "$f"
+`)
+}
+
+func TestVerticalVideoRelaxed(t *testing.T) {
+	verticalYTDLP(t)
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	_, err := d.Download(context.Background(), testURL, Options{MaxHeight: 720})
+	if !errors.Is(err, ErrFormatUnavailable) {
+		t.Fatalf("strict selector: error = %v, want ErrFormatUnavailable", err)
+	}
+
+	result, err := d.Download(context.Background(), testURL, Options{MaxHeight: 720, RelaxFormat: true})
+	if err != nil {
+		t.Fatalf("relaxed selector: %v", err)
+	}
+	data, err := os.ReadFile(result.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "res:720" {
+		t.Errorf("-S = %q, want res:720", got)
+	}
+}
+
+// argsYTDLP puts a yt-dlp on PATH that records its arguments, one per line,
+// and fails. It returns the path of the recorded arguments.
+func argsYTDLP(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "args")
+	installYTDLP(t, "printf '%s\\n' \"$@\" > '"+path+"'\nexit 1\n")
+	return path
+}
+
+func TestSponsorBlockArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		want     []string
+		unwanted string
+	}{
+		{"remove", Options{SponsorRemove: []string{"sponsor", "intro"}}, []string{"--sponsorblock-remove", "sponsor,intro"}, "--sponsorblock-mark"},
+		{"mark", Options{SponsorMark: []string{"selfpromo"}}, []string{"--sponsorblock-mark", "selfpromo"}, "--sponsorblock-remove"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := argsYTDLP(t)
+			d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+			d.hasFFmpeg = true
+			d.Download(context.Background(), testURL, tt.opts)
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := strings.Split(strings.TrimSpace(string(data)), "\n")
+			i := slices.Index(args, tt.want[0])
+			if i < 0 || i+1 >= len(args) || args[i+1] != tt.want[1] {
+				t.Errorf("args = %q, want %q", args, tt.want)
+			}
+			if slices.Contains(args, tt.unwanted) {
+				t.Errorf("args contain %s", tt.unwanted)
+			}
+		})
+	}
+}
+
+func TestSponsorBlockNeedsFFmpeg(t *testing.T) {
+	argsYTDLP(t)
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+	d.hasFFmpeg = false
+	for _, opts := range []Options{{SponsorRemove: []string{"sponsor"}}, {SponsorMark: []string{"sponsor"}}} {
+		if _, err := d.Download(context.Background(), testURL, opts); !errors.Is(err, ErrFFmpegRequired) {
+			t.Errorf("Download(%+v) error = %v, want ErrFFmpegRequired", opts, err)
+		}
+	}
+}
+
+func TestDownloadSkippedForSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   error
+	}{
+		{"over cap", "expected_size=52428800\n", ErrFileTooLarge},
+		{"approx over cap", "expected_size=5.24288e+07\n", ErrFileTooLarge},
+		{"under cap", "expected_size=1024\n", nil},
+		{"unknown size", "expected_size=NA\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeYTDLP(t, tt.output)
+			d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+			_, err := d.Download(context.Background(), testURL, Options{})
+			if err == nil {
+				t.Fatal("Download succeeded without a file")
+			}
+			if got := errors.Is(err, ErrFileTooLarge); got != (tt.want != nil) {
+				t.Errorf("Download error = %v, want ErrFileTooLarge %v", err, tt.want != nil)
+			}
+		})
+	}
+}
+
+func TestDownloadSkippedForRequestCap(t *testing.T) {
+	fakeYTDLP(t, "expected_size=2048\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	_, err := d.Download(context.Background(), testURL, Options{MaxFileSize: 1024})
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("Download error = %v, want ErrFileTooLarge", err)
+	}
+}
+
+func TestPlaylistSkippedForSize(t *testing.T) {
+	fakeYTDLP(t, "expected_size=52428800\nexpected_size=60000000\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	_, err := d.DownloadPlaylist(context.Background(), testURL, Options{}, 2)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("DownloadPlaylist error = %v, want ErrFileTooLarge", err)
+	}
+}
+
+func TestPlaylistTooLongBeforeDownload(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "downloaded")
+	installYTDLP(t, `for arg; do
+	[ "$arg" = --flat-playlist ] && printf '3000\nNA\n1000\n' && exit 0
+done
+touch '`+marker+"'\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	_, err := d.DownloadPlaylist(context.Background(), testURL, Options{}, 3)
+	if !errors.Is(err, ErrPlaylistTooLong) {
+		t.Errorf("DownloadPlaylist error = %v, want ErrPlaylistTooLong", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("entries were downloaded")
+	}
+}
+
+func TestPlaylistKeepsFinishedEntries(t *testing.T) {
+	// The first entry fails, leaving a partial file; without --ignore-errors
+	// yt-dlp stops there, with it the second entry downloads
+	installYTDLP(t, `while [ $# -gt 0 ]; do
+	[ "$1" = --flat-playlist ] && exit 0
+	[ "$1" = --ignore-errors ] && ignore=1
+	[ "$1" = -o ] && tmpl=$2
+	shift
+done
+entry() { printf '%s' "$tmpl" | sed "s/%(id)s/$1/; s/%(ext)s/mp4/"; }
+touch "$(entry abc).part"
+echo "ERROR: [youtube] abc: Video unavailable" >&2
+[ -n "$ignore" ] || exit 1
+echo '{"title": "Second", "duration": 60}'
+echo data > "$(entry def)"
+entry def; echo
+exit 1
+`)
+	dir := t.TempDir()
+	d := New(Config{TempDir: dir, MaxDuration: 3600, MaxFileSize: 1 << 20})
+
+	results, err := d.DownloadPlaylist(context.Background(), testURL, Options{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Title != "Second" {
+		t.Fatalf("results = %+v, want the second entry", results)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || files[0] != results[0].FilePath {
+		t.Errorf("temp dir holds %q, want only %s", files, results[0].FilePath)
+	}
+}
+
+// sponsorOutput is yt-dlp's quiet output for a download with SponsorBlock
+// segments cut out: the printed size, then the after_move metadata and path.
+const sponsorOutput = `expected_size=1024
+{"title": "Sponsored video", "duration": 95.4, "resolution": "1280x720", "vcodec": "avc1.64001F", "acodec": "mp4a.40.2", "tbr": 1210.4, "chapters": [{"start_time": 0.0, "end_time": 95.4, "title": "<Untitled Chapter 1>"}], "sponsorblock_chapters": [{"start_time": 12.1, "end_time": 31.7, "category": "sponsor", "title": "Sponsor", "type": "skip", "_categories": [["sponsor", 12.1, 31.7, "Sponsor"]]}, {"start_time": 88.0, "end_time": 95.4, "category": "outro", "title": "Endcards/Credits", "type": "skip", "_categories": [["outro", 88.0, 95.4, "Endcards/Credits"]]}]}
+FILE:abc
+`
+
+func TestDownloadSponsorsRemoved(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		remove []string
+		want   bool
+	}{
+		{"segment removed", sponsorOutput, []string{"sponsor"}, true},
+		{"other category", sponsorOutput, []string{"intro", "filler"}, false},
+		{"no segments", "expected_size=1024\n{\"title\": \"Plain\", \"duration\": 60}\nFILE:abc\n", []string{"sponsor"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeYTDLP(t, tt.output)
+			d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+			d.hasFFmpeg = true
+
+			result, err := d.Download(context.Background(), testURL, Options{SponsorRemove: tt.remove})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.SponsorsRemoved != tt.want {
+				t.Errorf("SponsorsRemoved = %v, want %v", result.SponsorsRemoved, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaylistSponsorsRemoved(t *testing.T) {
+	fakeYTDLP(t, sponsorOutput+"expected_size=2048\n{\"title\": \"Plain\", \"duration\": 60}\nFILE:def\n")
+	d := New(Config{TempDir: t.TempDir(), MaxDuration: 3600, MaxFileSize: 1 << 20})
+	d.hasFFmpeg = true
+
+	results, err := d.DownloadPlaylist(context.Background(), testURL, Options{SponsorRemove: []string{"sponsor"}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].SponsorsRemoved || results[1].SponsorsRemoved {
+		t.Fatalf("results = %+v", results)
+	}
+	if results[0].Title != "Sponsored video" || len(results[0].Chapters) != 1 {
+		t.Errorf("first entry = %+v", results[0])
+	}
+}
@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrEmptyPlaylist is returned when no playlist entry could be downloaded.
+	ErrEmptyPlaylist = errors.New("playlist has no downloadable entries")
+	// ErrPlaylistTooLong is returned when the downloaded entries together
+	// exceed the maximum duration.
+	ErrPlaylistTooLong = errors.New("playlist exceeds maximum total duration")
+)
+
+// playlistArgs returns the yt-dlp arguments downloading the first items
+// entries of a playlist, overriding baseArgs' --no-playlist. Entries that
+// fail are skipped rather than stopping the rest. items 0 keeps
+// single-video downloads.
+func playlistArgs(items int) []string {
+	if items <= 0 {
+		return nil
+	}
+	return []string{"--yes-playlist", "--playlist-end", strconv.Itoa(items), "--ignore-errors"}
+}
+
+// DownloadPlaylist downloads up to items entries of the playlist at
+// playlistURL, in playlist order. The file size and duration caps apply to
+// the entries together; a playlist over the duration cap is rejected before
+// anything is downloaded when the listing gives the entries' durations.
+// Entries over the duration cap are skipped, and entries that fail are left
+// out of the results as long as at least one succeeds.
+func (d *Downloader) DownloadPlaylist(ctx context.Context, playlistURL string, opts Options, items int) ([]*Result, error) {
+	if items <= 0 {
+		return nil, errors.New("playlist item count must be positive")
+	}
+	listed, err := d.playlistDuration(ctx, playlistURL, opts, items)
+	if err != nil {
+		return nil, err
+	}
+	if listed > float64(d.maxDuration) {
+		return nil, ErrPlaylistTooLong
+	}
+
+	output, timestamp, err := d.run(ctx, playlistURL, opts, items)
+	results := extractEntries(output, opts.SponsorRemove)
+	if err != nil {
+		if len(results) == 0 {
+			if timestamp != 0 {
+				removeGlob(filepath.Join(d.tempDir, fmt.Sprintf("%d_*", timestamp)))
+			}
+			return nil, err
+		}
+		// Keep the entries that finished; drop the failed ones' partial files
+		removeGlob(filepath.Join(d.tempDir, fmt.Sprintf("%d_*.part*", timestamp)))
+		slog.Warn("Some playlist entries failed", "downloaded", len(results), "error", err)
+	}
+
+	var total float64
+	for _, r := range results {
+		total += r.Duration
+	}
+	switch {
+	case len(results) == 0:
+		removeGlob(filepath.Join(d.tempDir, fmt.Sprintf("%d_*", timestamp)))
+		if skippedForSize(output, d.fileSizeCap(opts)) {
+			return nil, ErrFileTooLarge
+		}
+		return nil, ErrEmptyPlaylist
+	case total > float64(d.maxDuration):
+		removeGlob(filepath.Join(d.tempDir, fmt.Sprintf("%d_*", timestamp)))
+		return nil, ErrPlaylistTooLong
+	}
+	return results, nil
+}
+
+// playlistDuration lists the first items entries of a playlist without
+// downloading them and returns their total duration in seconds. Entries the
+// listing gives no duration for count as 0, and entries over the duration
+// cap are left out, as the download skips them.
+func (d *Downloader) playlistDuration(ctx context.Context, playlistURL string, opts Options, items int) (float64, error) {
+	if err := d.checkHost(ctx, playlistURL, d.proxyFor(opts)); err != nil {
+		return 0, err
+	}
+	release, err := d.acquireInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	cookies, cleanupCookies, err := d.cookieArgs(opts)
+	defer cleanupCookies()
+	if err != nil {
+		return 0, err
+	}
+	args := append(d.baseArgs(opts), playlistArgs(items)...)
+	args = append(args, cookies...)
+	args = append(args, "--flat-playlist", "--print", "%(duration)s", playlistURL)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// With --ignore-errors yt-dlp exits 1 when any entry failed, having
+	// still listed the others
+	if err := cmd.Run(); err != nil && strings.TrimSpace(stdout.String()) == "" {
+		return 0, d.classifyError(ctx, stderr.String(), playlistURL)
+	}
+
+	var total float64
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if n, err := strconv.ParseFloat(strings.TrimSpace(line), 64); err == nil {
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// extractEntries returns one result per downloaded playlist entry, pairing
+// each printed file path with the metadata line printed before it.
+func extractEntries(output string, remove []string) []*Result {
+	var results []*Result
+	current := &Result{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if parseMetadata(line, current, remove) {
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "[") || !strings.Contains(line, string(filepath.Separator)) {
+			continue
+		}
+		if info, err := os.Stat(line); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		current.FilePath = line
+		results = append(results, current)
+		current = &Result{}
+	}
+	return results
+}
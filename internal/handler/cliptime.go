@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ClipTime is a position in a video in seconds. In JSON it is a number of
+// seconds, or a string of seconds or [[HH:]MM:]SS.
+type ClipTime float64
+
+// UnmarshalJSON accepts 90, "90", "1:30" and "00:01:30".
+func (t *ClipTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var f float64
+		if err := json.Unmarshal(data, &f); err != nil {
+			return errors.New("time must be seconds or HH:MM:SS")
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	seconds, err := parseClipTime(s)
+	if err != nil {
+		return err
+	}
+	*t = ClipTime(seconds)
+	return nil
+}
+
+// maxClipTime bounds clip times, in seconds, well past any video's length.
+const maxClipTime = 7 * 24 * 3600
+
+// parseClipTime parses seconds or [[HH:]MM:]SS into seconds. NaN, infinite,
+// negative and out of range times are rejected.
+func parseClipTime(s string) (float64, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, errors.New("time must be seconds or HH:MM:SS")
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || math.Signbit(v) || (i > 0 && v >= 60) {
+			return 0, errors.New("time must be seconds or HH:MM:SS")
+		}
+		seconds = seconds*60 + v
+	}
+	if seconds > maxClipTime {
+		return 0, errors.New("time is longer than any video")
+	}
+	return seconds, nil
+}
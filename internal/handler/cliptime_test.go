@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClipTimeUnmarshal(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    ClipTime
+		wantErr bool
+	}{
+		{`90`, 90, false},
+		{`12.5`, 12.5, false},
+		{`"90"`, 90, false},
+		{`"1:30"`, 90, false},
+		{`"01:02:03"`, 3723, false},
+		{`" 0:05 "`, 5, false},
+		{`0`, 0, false},
+		{`"NaN"`, 0, true},
+		{`"nan"`, 0, true},
+		{`"Inf"`, 0, true},
+		{`"+Inf"`, 0, true},
+		{`"-Infinity"`, 0, true},
+		{`"1e308"`, 0, true},
+		{`1e308`, 0, true},
+		{`"1e308:00"`, 0, true},
+		{`-1`, 0, true},
+		{`"-0:30"`, 0, true},
+		{`"1:60"`, 0, true},
+		{`"1:2:3:4"`, 0, true},
+		{`"abc"`, 0, true},
+		{`""`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		var got ClipTime
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, wantErr %v", tt.json, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.json, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"slices"
+	"strings"
+)
+
+// DefaultDomainPatterns match the regional domains of allowlisted
+// platforms. A trailing ".*" stands for one of regionalTLDs (youtube.de,
+// youtube.co.uk, youtube.com.br); any other suffix, like youtube.tk or
+// youtube.evil.com, is rejected.
+var DefaultDomainPatterns = []string{"youtube.*"}
+
+// regionalTLDs are the top-level domains YouTube serves its regional sites
+// on, the only ones a ".*" pattern matches.
+var regionalTLDs = []string{
+	"com", "ae", "at", "az", "ba", "be", "bg", "bh", "bo", "by", "ca",
+	"cat", "ch", "cl", "co", "cr", "cz", "de", "dk", "ee", "es", "fi",
+	"fr", "ge", "gr", "gt", "hk", "hr", "hu", "ie", "in", "iq", "is",
+	"it", "jo", "jp", "kr", "kz", "la", "lk", "lt", "lu", "lv", "ly",
+	"ma", "md", "me", "mk", "mn", "mx", "my", "ng", "ni", "nl", "no",
+	"pa", "pe", "ph", "pk", "pl", "pr", "pt", "qa", "ro", "rs", "ru",
+	"sa", "se", "sg", "si", "sk", "sn", "soy", "sv", "tn", "tv", "ua",
+	"ug", "uy", "vn",
+	"co.ae", "co.at", "co.cr", "co.hu", "co.id", "co.il", "co.in",
+	"co.jp", "co.ke", "co.kr", "co.ma", "co.nz", "co.th", "co.tz",
+	"co.ug", "co.uk", "co.ve", "co.za", "co.zw",
+	"com.ar", "com.au", "com.az", "com.bd", "com.bh", "com.bo",
+	"com.br", "com.by", "com.co", "com.do", "com.ec", "com.ee",
+	"com.eg", "com.es", "com.gh", "com.gr", "com.gt", "com.hk",
+	"com.hn", "com.hr", "com.jm", "com.jo", "com.kw", "com.lb",
+	"com.lv", "com.ly", "com.mk", "com.mt", "com.mx", "com.my",
+	"com.ng", "com.ni", "com.om", "com.pa", "com.pe", "com.ph",
+	"com.pk", "com.pt", "com.py", "com.qa", "com.ro", "com.sa",
+	"com.sg", "com.sv", "com.tn", "com.tr", "com.tw", "com.ua",
+	"com.uy", "com.ve",
+}
+
+// domainPattern matches a host and its subdomains against one pattern.
+type domainPattern struct {
+	base     string
+	regional bool
+}
+
+// newDomainPattern parses pattern. Patterns without a trailing ".*"
+// match the domain literally.
+func newDomainPattern(pattern string) domainPattern {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	base, regional := strings.CutSuffix(pattern, ".*")
+	return domainPattern{base: base, regional: regional}
+}
+
+// match reports whether host, already lowercased, matches the pattern.
+func (p domainPattern) match(host string) bool {
+	for h := host; h != ""; {
+		if !p.regional && h == p.base {
+			return true
+		}
+		if tld, ok := strings.CutPrefix(h, p.base+"."); p.regional && ok && slices.Contains(regionalTLDs, tld) {
+			return true
+		}
+		_, rest, ok := strings.Cut(h, ".")
+		if !ok {
+			break
+		}
+		h = rest
+	}
+	return false
+}
+
+// allowedHost reports whether host is an allowlisted domain, a subdomain of
+// one, or matches a configured domain pattern.
+func (h *Handler) allowedHost(host string) bool {
+	for _, domain := range h.cfg.AllowedDomains {
+		d := strings.TrimPrefix(strings.ToLower(domain), "www.")
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	for _, p := range h.patterns {
+		if p.match(host) {
+			return true
+		}
+	}
+	return false
+}
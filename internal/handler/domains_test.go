@@ -0,0 +1,54 @@
+package handler
+
+import "testing"
+
+func TestDomainPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"youtube.*", "youtube.com", true},
+		{"youtube.*", "m.youtube.com", true},
+		{"youtube.*", "youtube.de", true},
+		{"youtube.*", "www.youtube.co.uk", true},
+		{"youtube.*", "youtube.com.br", true},
+		{"youtube.*", "youtube.tk", false},
+		{"youtube.*", "youtube.ws", false},
+		{"youtube.*", "youtube.co.tk", false},
+		{"youtube.*", "youtube.evil.com", false},
+		{"youtube.*", "youtube.com.evil.com", false},
+		{"youtube.*", "notyoutube.com", false},
+		{"youtube.*", "youtube", false},
+		{"youtube.*", "com", false},
+		{"example.org", "example.org", true},
+		{"example.org", "cdn.example.org", true},
+		{"example.org", "example.org.evil.com", false},
+		{"example.org", "badexample.org", false},
+		{" Example.ORG ", "example.org", true},
+	}
+	for _, tt := range tests {
+		if got := newDomainPattern(tt.pattern).match(tt.host); got != tt.want {
+			t.Errorf("%q.match(%q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedHost(t *testing.T) {
+	f := newFakeHandler(t, Config{AllowedDomains: []string{"www.vimeo.com"}})
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"vimeo.com", true},
+		{"player.vimeo.com", true},
+		{"vimeo.com.evil.com", false},
+		{"youtube.fr", true},
+		{"youtube.ws", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		if got := f.h.allowedHost(tt.host); got != tt.want {
+			t.Errorf("allowedHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
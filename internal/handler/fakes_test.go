@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+	"github.com/emanuelef/yt-dl-api-go/internal/notifier"
+	"github.com/emanuelef/yt-dl-api-go/internal/storage"
+)
+
+// fakeDownloader "downloads" by writing a small file into dir.
+type fakeDownloader struct {
+	dir string
+	// err, when set, is returned by every download.
+	err error
+	// failures are returned, in order, by the first downloads.
+	failures []error
+	// strictErr, when set, is returned by downloads without RelaxFormat.
+	strictErr error
+	// block, when set, holds downloads until it is closed.
+	block chan struct{}
+	// raw is returned by RawInfo.
+	raw json.RawMessage
+	// checkErr is returned by Check.
+	checkErr error
+
+	mu     sync.Mutex
+	calls  []downloader.Options
+	n      int
+	checks int
+}
+
+func newFakeDownloader(dir string) *fakeDownloader {
+	return &fakeDownloader{dir: dir}
+}
+
+// fakeContent is the content of every downloaded file.
+const fakeContent = "fake video data"
+
+// fakeDuration is the duration of every downloaded video.
+const fakeDuration = 42.5
+
+func (d *fakeDownloader) Download(ctx context.Context, videoURL string, opts downloader.Options) (*downloader.Result, error) {
+	d.mu.Lock()
+	d.calls = append(d.calls, opts)
+	d.mu.Unlock()
+	if d.block != nil {
+		var timeout <-chan time.Time
+		if opts.Timeout > 0 {
+			timeout = time.After(opts.Timeout)
+		}
+		select {
+		case <-d.block:
+		case <-timeout:
+			return nil, errors.New("download timed out")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.failures) > 0 {
+		err := d.failures[0]
+		d.failures = d.failures[1:]
+		return nil, err
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.strictErr != nil && !opts.RelaxFormat {
+		return nil, d.strictErr
+	}
+	return d.write("video")
+}
+
+func (d *fakeDownloader) DownloadPlaylist(ctx context.Context, playlistURL string, opts downloader.Options, items int) ([]*downloader.Result, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, opts)
+	if d.err != nil {
+		return nil, d.err
+	}
+	var results []*downloader.Result
+	for i := range items {
+		result, err := d.write(fmt.Sprintf("entry%d", i))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// callCount returns the number of calls made so far.
+func (d *fakeDownloader) callCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.calls)
+}
+
+// write creates the next file, titled after id; d.mu must be held.
+func (d *fakeDownloader) write(id string) (*downloader.Result, error) {
+	d.n++
+	path := filepath.Join(d.dir, fmt.Sprintf("%d_%s.mp4", d.n, id))
+	if err := os.WriteFile(path, []byte(fakeContent), 0644); err != nil {
+		return nil, err
+	}
+	return &downloader.Result{FilePath: path, Title: "Title " + id, Duration: fakeDuration}, nil
+}
+
+func (d *fakeDownloader) Resolve(ctx context.Context, videoURL string, opts downloader.Options) (*downloader.Format, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, opts)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &downloader.Format{FormatID: "22", Ext: "mp4", Title: "Title video"}, nil
+}
+
+func (d *fakeDownloader) RawInfo(ctx context.Context, videoURL string) (json.RawMessage, error) {
+	return d.raw, d.err
+}
+
+func (d *fakeDownloader) Check(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.checks++
+	return d.checkErr
+}
+
+// memoryStorage keeps uploaded files in memory.
+type memoryStorage struct {
+	// err, when set, fails every upload, or those of files whose name
+	// contains failName when that is set too.
+	err      error
+	failName string
+	// delay holds each upload, so concurrent ones overlap.
+	delay time.Duration
+	// checkErr is returned by Check.
+	checkErr error
+
+	mu          sync.Mutex
+	objects     map[string][]byte
+	titles      map[string]string
+	inFlight    int
+	maxInFlight int
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{objects: make(map[string][]byte), titles: make(map[string]string)}
+}
+
+func (s *memoryStorage) Upload(ctx context.Context, filePath string, opts storage.UploadOptions) (string, error) {
+	s.mu.Lock()
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	if s.err != nil && strings.Contains(filepath.Base(filePath), s.failName) {
+		return "", s.err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.Base(filePath)
+	s.mu.Lock()
+	s.objects[key] = data
+	s.titles[key] = opts.Title
+	s.mu.Unlock()
+	return "https://storage.test/" + key, nil
+}
+
+func (s *memoryStorage) SupportsURLType(urlType string) bool { return true }
+
+func (s *memoryStorage) Check(ctx context.Context) error { return s.checkErr }
+
+func (s *memoryStorage) Cleanup(filePath string) error { return os.Remove(filePath) }
+
+// object returns an uploaded file's content.
+func (s *memoryStorage) object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	return data, ok
+}
+
+// memoryCache is a Cache without expiry. It records the TTL each entry
+// was stored with.
+type memoryCache struct {
+	mu    sync.Mutex
+	infos map[string]*downloader.Format
+	ttls  map[string]time.Duration
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{infos: make(map[string]*downloader.Format), ttls: make(map[string]time.Duration)}
+}
+
+func (c *memoryCache) Get(key string) (*downloader.Format, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, ok := c.infos[key]
+	return info, ok
+}
+
+func (c *memoryCache) Set(key string, info *downloader.Format, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.infos[key] = info
+	c.ttls[key] = ttl
+}
+
+// fakes bundles a Handler with the fakes it was built on.
+type fakes struct {
+	h     *Handler
+	dl    *fakeDownloader
+	store *memoryStorage
+	cache *memoryCache
+}
+
+// newFakeHandler creates a Handler backed by fakes, with files in a
+// temporary directory.
+func newFakeHandler(t testing.TB, cfg Config) fakes {
+	f := fakes{
+		dl:    newFakeDownloader(t.TempDir()),
+		store: newMemoryStorage(),
+		cache: newMemoryCache(),
+	}
+	f.h = New(f.dl, f.store, f.cache, notifier.Noop{}, cfg)
+	return f
+}
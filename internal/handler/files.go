@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/sign"
+	"github.com/emanuelef/yt-dl-api-go/internal/storage"
+)
+
+// FileStore is implemented by storages that keep files on this server.
+type FileStore interface {
+	Open(name string, query url.Values) (*os.File, error)
+}
+
+// File handles GET /api/files/{name}, serving a locally stored download.
+// http.ServeContent handles Range and conditional requests, so interrupted
+// downloads can be resumed.
+func (h *Handler) File(w http.ResponseWriter, r *http.Request) {
+	files, ok := h.store.(FileStore)
+	if !ok {
+		h.errorJSON(w, "File not found", "NOT_FOUND", http.StatusNotFound)
+		return
+	}
+	file, err := files.Open(r.PathValue("name"), r.URL.Query())
+	switch {
+	case errors.Is(err, sign.ErrExpired):
+		h.errorJSON(w, "Download link has expired", "LINK_EXPIRED", http.StatusForbidden)
+		return
+	case errors.Is(err, storage.ErrFileForbidden):
+		h.errorJSON(w, "Invalid download link", "INVALID_SIGNATURE", http.StatusForbidden)
+		return
+	case err != nil:
+		h.errorJSON(w, "File not found", "NOT_FOUND", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		h.errorJSON(w, "File not found", "NOT_FOUND", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", storage.ContentType(file.Name()))
+	w.Header().Set("Content-Disposition", storage.ContentDisposition(file.Name(), r.URL.Query().Get("title")))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+)
+
+// flightGroup makes sure only one download per key runs at a time.
+// Concurrent callers for the same key wait for it and share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	resp DownloadResponse
+	err  error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs fn for key unless a call is already in flight, in which case it
+// waits for that call. shared reports whether the result came from another caller.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (DownloadResponse, error)) (resp DownloadResponse, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.resp, true, c.err
+		case <-ctx.Done():
+			return DownloadResponse{}, true, ctx.Err()
+		}
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.resp, c.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+
+	return c.resp, false, c.err
+}
+
+// infoKey is the info cache key of videoURL resolved with opts. The default
+// quality uses videoKey alone, which prewarmed entries are stored under.
+func infoKey(videoURL string, opts downloader.Options) string {
+	key := videoKey(videoURL)
+	if opts.Media != "" && opts.Media != downloader.MediaVideo {
+		key += "|" + opts.Media
+	}
+	if opts.MaxHeight != 0 && opts.MaxHeight != downloader.DefaultMaxHeight {
+		key += "|" + strconv.Itoa(opts.MaxHeight)
+	}
+	return key
+}
+
+// videoKey identifies the video behind a URL, so different URL forms of
+// the same YouTube video map to one key. Other platforms use host and path.
+func videoKey(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	host = strings.TrimPrefix(host, "m.")
+	path := strings.TrimSuffix(parsed.Path, "/")
+
+	switch {
+	case host == "youtu.be":
+		return "youtube:" + strings.TrimPrefix(path, "/")
+	case host == "youtube.com" || strings.HasSuffix(host, ".youtube.com"):
+		if id := parsed.Query().Get("v"); id != "" {
+			return "youtube:" + id
+		}
+		if id, ok := strings.CutPrefix(path, "/shorts/"); ok {
+			return "youtube:" + id
+		}
+	}
+	return host + path
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSingleFlightDownload(t *testing.T) {
+	f := newFakeHandler(t, Config{SingleFlight: true})
+	f.dl.block = make(chan struct{})
+
+	// Different URL forms of the same video
+	urls := []string{
+		"https://www.youtube.com/watch?v=abc123",
+		"https://youtu.be/abc123",
+		"https://m.youtube.com/watch?v=abc123",
+	}
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, len(urls))
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = postDownload(t, f.h, `{"url":"`+u+`"}`, nil)
+		}()
+	}
+
+	// Let the first download start and the other requests join it
+	for f.dl.callCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(f.dl.block)
+	wg.Wait()
+
+	if n := f.dl.callCount(); n != 1 {
+		t.Errorf("downloads = %d, want 1", n)
+	}
+	var first string
+	sources := make(map[string]int)
+	for i, rec := range recs {
+		var resp DownloadResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, %v", urls[i], rec.Code, err)
+		}
+		if i == 0 {
+			first = resp.DownloadURL
+		} else if resp.DownloadURL != first {
+			t.Errorf("%s: download URL %q, want %q", urls[i], resp.DownloadURL, first)
+		}
+		sources[resp.Source]++
+	}
+	if sources[SourceFresh] != 1 || sources[SourceDedup] != len(urls)-1 {
+		t.Errorf("sources = %v, want one %s and the rest %s", sources, SourceFresh, SourceDedup)
+	}
+}
+
+func TestVideoKey(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://www.youtube.com/watch?v=abc&t=10", "youtube:abc"},
+		{"https://youtu.be/abc", "youtube:abc"},
+		{"https://m.youtube.com/shorts/abc/", "youtube:abc"},
+		{"https://music.youtube.com/watch?v=abc", "youtube:abc"},
+		{"https://vimeo.com/123", "vimeo.com/123"},
+		{"https://www.tiktok.com/@user/video/1", "tiktok.com/@user/video/1"},
+	}
+	for _, tt := range tests {
+		if got := videoKey(tt.url); got != tt.want {
+			t.Errorf("videoKey(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -2,59 +2,316 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"expvar"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+	"github.com/emanuelef/yt-dl-api-go/internal/middleware"
+	"github.com/emanuelef/yt-dl-api-go/internal/netguard"
+	"github.com/emanuelef/yt-dl-api-go/internal/notifier"
+	"github.com/emanuelef/yt-dl-api-go/internal/redact"
+	"github.com/emanuelef/yt-dl-api-go/internal/storage"
+	"github.com/emanuelef/yt-dl-api-go/internal/urlprep"
 )
 
 // Downloader defines the interface for video downloading.
 type Downloader interface {
-	Download(ctx context.Context, videoURL string) (filePath string, err error)
+	Download(ctx context.Context, videoURL string, opts downloader.Options) (*downloader.Result, error)
+	DownloadPlaylist(ctx context.Context, playlistURL string, opts downloader.Options, items int) ([]*downloader.Result, error)
+	Resolve(ctx context.Context, videoURL string, opts downloader.Options) (*downloader.Format, error)
+	RawInfo(ctx context.Context, videoURL string) (json.RawMessage, error)
+	Check(ctx context.Context) error
 }
 
 // Storage defines the interface for file storage.
 type Storage interface {
-	Upload(ctx context.Context, filePath string) (publicURL string, err error)
+	Upload(ctx context.Context, filePath string, opts storage.UploadOptions) (url string, err error)
+	SupportsURLType(urlType string) bool
+	Check(ctx context.Context) error
 	Cleanup(filePath string) error
 }
 
+// Cache defines the interface for caching video info.
+type Cache interface {
+	Get(key string) (*downloader.Format, bool)
+	Set(key string, info *downloader.Format, ttl time.Duration)
+}
+
+// Notifier defines the interface for download completion notifications.
+type Notifier interface {
+	Notify(ctx context.Context, n notifier.Notification) error
+}
+
+// Download modes control which URLs may be downloaded.
+const (
+	// ModeAllowlist only accepts URLs from the allowed domain list.
+	ModeAllowlist = "allowlist"
+	// ModeSSRFOnly accepts any host that resolves to public addresses only.
+	ModeSSRFOnly = "ssrf_only"
+)
+
+// Config holds handler behaviour settings.
+type Config struct {
+	// DownloadMode is ModeAllowlist (default) or ModeSSRFOnly.
+	DownloadMode string
+	// AllowedDomains is the domain allowlist; nil uses DefaultAllowedDomains.
+	AllowedDomains []string
+	// DomainPatterns extend the allowlist with regional domains; nil uses
+	// DefaultDomainPatterns.
+	DomainPatterns []string
+	// SingleFlight allows only one active download per video; concurrent
+	// requests for the same video wait and reuse its result.
+	SingleFlight bool
+	// DegradedReason, when set, is reported by the health check.
+	DegradedReason string
+	// ByteQuota caps bytes downloaded per client IP in ByteQuotaWindow (0 disables).
+	ByteQuota       int64
+	ByteQuotaWindow time.Duration
+	// PreviewMaxSeconds caps preview_seconds; 0 disables preview clips.
+	PreviewMaxSeconds int
+	// PreviewMaxFileSize caps the size of preview clips in bytes.
+	PreviewMaxFileSize int64
+	// MaxDuration caps the length of a requested clip in seconds (0 disables).
+	// It is also the downloader's video length limit, quoted in errors.
+	MaxDuration int
+	// MaxFileSize is the downloader's file size limit in bytes, quoted in
+	// errors (0 leaves it out).
+	MaxFileSize int64
+	// CacheTTLOverrides sets the info cache TTL per platform domain (e.g.
+	// "twitch.tv"), taking precedence over the cache's default TTL.
+	CacheTTLOverrides map[string]time.Duration
+	// MaxJobDuration bounds a whole download request: yt-dlp, post-processing
+	// and upload together.
+	MaxJobDuration time.Duration
+	// MetadataOnFailure includes the video's metadata (title, duration,
+	// thumbnail) in failed download responses when it can be resolved.
+	MetadataOnFailure bool
+	// MaxRetries is how many times a transient download failure (network
+	// errors, upstream 5xx or 429) is retried with backoff (0 disables).
+	MaxRetries int
+	// MaxDowngrades is how many times a video download that hits the file
+	// size limit is retried at the next lower height (0 disables).
+	MaxDowngrades int
+	// PlatformConcurrency caps simultaneous downloads per platform (e.g.
+	// "youtube": 2); further downloads wait for a slot. Unlisted platforms
+	// are unlimited.
+	PlatformConcurrency map[string]int
+	// AllowRequestCookies accepts per-request cookies (DownloadRequest.Cookies).
+	AllowRequestCookies bool
+	// AllowRequestProxy accepts per-request proxies (DownloadRequest.Proxy).
+	AllowRequestProxy bool
+	// KeyDailyQuota caps successful downloads per API key per 24 hours
+	// (0 = unlimited). Requests without an API key are not counted.
+	KeyDailyQuota int64
+	// SponsorCategories are the SponsorBlock categories removed or marked
+	// when a request names none; nil uses DefaultSponsorCategories.
+	SponsorCategories []string
+	// PlaylistMaxItems caps the entries of a playlist download; 0 disables
+	// playlist downloads.
+	PlaylistMaxItems int
+	// PlaylistUploadConcurrency caps the playlist entries uploaded at once;
+	// values under 1 upload one at a time.
+	PlaylistUploadConcurrency int
+	// PlaylistPartialResults returns the entries that uploaded when others
+	// failed, each failed one carrying its error, instead of failing the
+	// whole request. The request still fails if every upload does.
+	PlaylistPartialResults bool
+}
+
 // Handler holds dependencies for HTTP handlers.
 type Handler struct {
-	dl    Downloader
-	store Storage
+	dl       Downloader
+	store    Storage
+	cache    Cache
+	notify   Notifier
+	cfg      Config
+	flight   *flightGroup
+	quota    *usageQuota
+	keyQuota *usageQuota
+	resolver netguard.Resolver
+	prep     urlprep.Pipeline
+	patterns []domainPattern
+	platform *platformLimiter
+	ready    readiness
 }
 
 // New creates a new Handler.
-func New(dl Downloader, store Storage) *Handler {
-	return &Handler{dl: dl, store: store}
+func New(dl Downloader, store Storage, cache Cache, notify Notifier, cfg Config) *Handler {
+	if cfg.MaxJobDuration <= 0 {
+		cfg.MaxJobDuration = 5 * time.Minute
+	}
+	if cfg.AllowedDomains == nil {
+		cfg.AllowedDomains = DefaultAllowedDomains
+	}
+	if cfg.DomainPatterns == nil {
+		cfg.DomainPatterns = DefaultDomainPatterns
+	}
+	if cfg.SponsorCategories == nil {
+		cfg.SponsorCategories = DefaultSponsorCategories
+	}
+	patterns := make([]domainPattern, len(cfg.DomainPatterns))
+	for i, p := range cfg.DomainPatterns {
+		patterns[i] = newDomainPattern(p)
+	}
+	return &Handler{
+		dl:       dl,
+		store:    store,
+		cache:    cache,
+		notify:   notify,
+		cfg:      cfg,
+		flight:   newFlightGroup(),
+		quota:    newUsageQuota(cfg.ByteQuota, cfg.ByteQuotaWindow),
+		keyQuota: newUsageQuota(cfg.KeyDailyQuota, 24*time.Hour),
+		resolver: net.DefaultResolver,
+		prep:     urlprep.Default,
+		patterns: patterns,
+		platform: newPlatformLimiter(cfg.PlatformConcurrency),
+	}
 }
 
 // DownloadRequest is the expected JSON body for POST /api/download.
 type DownloadRequest struct {
-	URL string `json:"url"`
+	URL           string `json:"url"`
+	Format        string `json:"format,omitempty"`      // "video" (default) or "audio"
+	AudioCodec    string `json:"audio_codec,omitempty"` // mp3, m4a or flac; audio only
+	FormatID      string `json:"format_id,omitempty"`
+	AudioLanguage string `json:"audio_language,omitempty"`
+	MaxHeight     int    `json:"max_height,omitempty"` // e.g. 480, 720, 1080, 2160
+	EmbedChapters bool   `json:"embed_chapters,omitempty"`
+	// SponsorBlock is "remove" to cut SponsorBlock segments or "mark" to
+	// mark them as chapters; RemoveSponsors is the same as "remove".
+	// SponsorCategories defaults to the server's categories. Ignored for
+	// videos not on YouTube.
+	SponsorBlock      string   `json:"sponsorblock,omitempty"`
+	RemoveSponsors    bool     `json:"remove_sponsors,omitempty"`
+	SponsorCategories []string `json:"sponsor_categories,omitempty"`
+	// TimeoutSeconds bounds the download, clamped to the server's limits;
+	// a download that takes longer fails with TIMEOUT.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// StartTime and EndTime download only that section of the video.
+	StartTime ClipTime `json:"start_time,omitempty"`
+	EndTime   ClipTime `json:"end_time,omitempty"`
+	// PreviewSeconds also produces a clip of the first N seconds.
+	PreviewSeconds int    `json:"preview_seconds,omitempty"`
+	URLType        string `json:"url_type,omitempty"`     // "presigned" (default) or "public"
+	Email          string `json:"email,omitempty"`        // notified when the download finishes
+	CallbackURL    string `json:"callback_url,omitempty"` // POSTed the result when the download finishes
+	// Cookies is a base64 Netscape cookies file for login-gated videos,
+	// accepted only when the server enables request cookies.
+	Cookies string `json:"cookies,omitempty"`
+	// Proxy routes this download through the given proxy URL, accepted
+	// only when the server enables request proxies.
+	Proxy string `json:"proxy,omitempty"`
+	// LimitRate lowers the server's download speed cap, e.g. "500K" or "2M".
+	LimitRate string `json:"limit_rate,omitempty"`
+	// Playlist downloads the first MaxItems entries of a playlist URL
+	// (default and maximum: the server's cap) instead of a single video.
+	Playlist bool `json:"playlist,omitempty"`
+	MaxItems int  `json:"max_items,omitempty"`
 }
 
 // DownloadResponse is the JSON response for successful downloads.
 type DownloadResponse struct {
-	DownloadURL string `json:"download_url"`
-	Title       string `json:"title,omitempty"`
+	DownloadURL      string  `json:"download_url"`
+	PreviewURL       string  `json:"preview_url,omitempty"`
+	Title            string  `json:"title,omitempty"`
+	Duration         float64 `json:"duration,omitempty"` // Seconds
+	Filesize         int64   `json:"filesize,omitempty"`
+	FileExt          string  `json:"file_ext,omitempty"`
+	ContentType      string  `json:"content_type,omitempty"`
+	ChaptersEmbedded bool    `json:"chapters_embedded,omitempty"`
+	SponsorsRemoved  bool    `json:"sponsors_removed,omitempty"`
+	ActualResolution string  `json:"actual_resolution,omitempty"`
+	ActualVCodec     string  `json:"actual_vcodec,omitempty"`
+	ActualACodec     string  `json:"actual_acodec,omitempty"`
+	ActualBitrate    float64 `json:"actual_bitrate,omitempty"` // kbit/s
+	MaxHeight        int     `json:"max_height,omitempty"`     // Height cap actually used, after any downgrade
+	Source           string  `json:"source"`
+	URLType          string  `json:"url_type"`
+	// Chapters lists the video's chapters, if it has any.
+	Chapters []downloader.Chapter `json:"chapters,omitempty"`
+	// Items lists the downloaded entries of a playlist, in playlist order.
+	// Duration and Filesize are then the uploaded entries' totals and
+	// DownloadURL is empty.
+	Items []DownloadResponse `json:"items,omitempty"`
+	// Error explains why a playlist entry has no DownloadURL, when partial
+	// playlist results are enabled.
+	Error string `json:"error,omitempty"`
 }
 
+// InfoResponse is the JSON response for GET /api/info.
+type InfoResponse struct {
+	*downloader.Format
+	Source string `json:"source"`
+}
+
+// SponsorBlock modes for DownloadRequest.SponsorBlock.
+const (
+	SponsorBlockRemove = "remove"
+	SponsorBlockMark   = "mark"
+)
+
+// DefaultSponsorCategories are the SponsorBlock categories used when neither
+// the request nor the server configuration names any.
+var DefaultSponsorCategories = []string{"sponsor"}
+
+// Sources report how a response was obtained.
+const (
+	// SourceFresh means the work was done for this request.
+	SourceFresh = "fresh"
+	// SourceCache means the result came from the video info cache.
+	SourceCache = "cache"
+	// SourceDedup means the request joined an identical in-flight download.
+	SourceDedup = "dedup"
+)
+
 // ErrorResponse is the standard error response format.
 type ErrorResponse struct {
 	Error string `json:"error"`
 	Code  string `json:"code,omitempty"`
+	// Video carries the video's metadata when a download failed after it was known.
+	Video *downloader.Format `json:"video,omitempty"`
 }
 
-// Allowed domains for video downloads (security whitelist).
-var allowedDomains = []string{
+// maxCookiesSize caps the decoded size of request cookies.
+const maxCookiesSize = 64 << 10
+
+// upstreamRetryAfter is the Retry-After value (seconds) sent when the platform rate limits us.
+const upstreamRetryAfter = "60"
+
+// errUpload marks failures of the storage upload step.
+var errUpload = errors.New("upload failed")
+
+// formatIDPattern matches yt-dlp format IDs such as "22" or "137+140".
+var formatIDPattern = regexp.MustCompile(`^[A-Za-z0-9_+-]{1,64}$`)
+
+// languagePattern matches language codes such as "en", "pt-BR" or "es-419".
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// audioCodecs are the accepted audio_codec values.
+var audioCodecs = map[string]bool{"mp3": true, "m4a": true, "flac": true}
+
+// DefaultAllowedDomains are the domains videos may be downloaded from in
+// ModeAllowlist, along with their subdomains (security whitelist).
+var DefaultAllowedDomains = []string{
 	"youtube.com", "youtu.be", "www.youtube.com", "m.youtube.com",
+	"youtubekids.com", "youtube-nocookie.com",
 	"tiktok.com", "www.tiktok.com", "vm.tiktok.com",
 	"instagram.com", "www.instagram.com",
 	"twitter.com", "x.com", "www.twitter.com",
@@ -69,17 +326,37 @@ var allowedDomains = []string{
 // Health handles GET /api/health.
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if h.cfg.DegradedReason != "" {
+		json.NewEncoder(w).Encode(map[string]string{"status": "degraded", "reason": h.cfg.DegradedReason})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// Ready handles GET /api/ready, checking that yt-dlp and storage work.
+// It answers 503 with a per-check breakdown when any of them fails. The
+// result is cached for readyCacheTTL.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	status, results := h.ready.get(func() (int, map[string]string) {
+		return h.checkReady(r.Context())
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
+}
+
 // Options handles preflight CORS requests.
 func (h *Handler) Options(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
 // Download handles POST /api/download.
+//
+// With ?preview=true it only resolves the format that would be downloaded;
+// clients confirm by sending the returned format_id in a follow-up request.
 func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.MaxJobDuration)
 	defer cancel()
 
 	// Parse request
@@ -89,95 +366,712 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate URL
-	if err := h.validateURL(req.URL); err != nil {
-		h.errorJSON(w, err.Error(), "INVALID_URL", http.StatusBadRequest)
+	// Canonicalize and validate URL
+	req.URL = h.prep.Apply(req.URL)
+	if err := h.validateURL(ctx, req.URL); err != nil {
+		h.errorJSON(w, err.Error(), urlErrorCode(err), http.StatusBadRequest)
+		return
+	}
+
+	if req.FormatID != "" && !formatIDPattern.MatchString(req.FormatID) {
+		h.errorJSON(w, "Invalid format_id", "INVALID_FORMAT", http.StatusBadRequest)
+		return
+	}
+	if req.Email != "" {
+		if err := notifier.ValidateEmail(req.Email); err != nil {
+			h.errorJSON(w, "Invalid email address", "INVALID_EMAIL", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.CallbackURL != "" {
+		if err := h.validateCallbackURL(ctx, req.CallbackURL); err != nil {
+			h.errorJSON(w, err.Error(), "INVALID_CALLBACK_URL", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.AudioLanguage != "" && !languagePattern.MatchString(req.AudioLanguage) {
+		h.errorJSON(w, "Invalid audio_language", "INVALID_LANGUAGE", http.StatusBadRequest)
+		return
+	}
+	if req.Format != "" && req.Format != downloader.MediaVideo && req.Format != downloader.MediaAudio {
+		h.errorJSON(w, `format must be "video" or "audio"`, "INVALID_FORMAT", http.StatusBadRequest)
+		return
+	}
+	if req.AudioCodec != "" && (req.Format != downloader.MediaAudio || !audioCodecs[req.AudioCodec]) {
+		h.errorJSON(w, `audio_codec must be mp3, m4a or flac and requires format "audio"`, "INVALID_FORMAT", http.StatusBadRequest)
+		return
+	}
+	if req.MaxHeight != 0 && !downloader.IsAllowedHeight(req.MaxHeight) {
+		h.errorJSON(w, fmt.Sprintf("max_height must be one of %v", downloader.AllowedHeights), "INVALID_FORMAT", http.StatusBadRequest)
+		return
+	}
+	if req.URLType == "" {
+		req.URLType = storage.URLPresigned
+		if !h.store.SupportsURLType(req.URLType) {
+			req.URLType = storage.URLPublic
+		}
+	}
+	if !h.store.SupportsURLType(req.URLType) {
+		h.errorJSON(w, fmt.Sprintf("url_type %q is not available on this server", req.URLType), "INVALID_URL_TYPE", http.StatusBadRequest)
+		return
+	}
+	if req.RemoveSponsors {
+		if req.SponsorBlock != "" && req.SponsorBlock != SponsorBlockRemove {
+			h.errorJSON(w, `remove_sponsors conflicts with sponsorblock "`+req.SponsorBlock+`"`, "INVALID_SPONSORBLOCK", http.StatusBadRequest)
+			return
+		}
+		req.SponsorBlock = SponsorBlockRemove
+	}
+	if req.SponsorBlock != "" && req.SponsorBlock != SponsorBlockRemove && req.SponsorBlock != SponsorBlockMark {
+		h.errorJSON(w, `sponsorblock must be "remove" or "mark"`, "INVALID_SPONSORBLOCK", http.StatusBadRequest)
+		return
+	}
+	if len(req.SponsorCategories) > 0 && req.SponsorBlock == "" {
+		h.errorJSON(w, "sponsor_categories requires sponsorblock", "INVALID_SPONSORBLOCK", http.StatusBadRequest)
+		return
+	}
+	if req.SponsorBlock != "" && len(req.SponsorCategories) == 0 {
+		req.SponsorCategories = h.cfg.SponsorCategories
+	}
+	for _, c := range req.SponsorCategories {
+		if !downloader.IsSponsorCategory(c) {
+			h.errorJSON(w, fmt.Sprintf("sponsor_categories must be from %v", downloader.SponsorCategories), "INVALID_SPONSORBLOCK", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.SponsorBlock != "" && platformOf(req.URL) != "youtube" {
+		// Only YouTube has SponsorBlock data; skip it rather than require ffmpeg for nothing
+		req.SponsorBlock, req.SponsorCategories = "", nil
+	}
+	var sponsorRemove, sponsorMark []string
+	switch req.SponsorBlock {
+	case SponsorBlockRemove:
+		sponsorRemove = req.SponsorCategories
+	case SponsorBlockMark:
+		sponsorMark = req.SponsorCategories
+	}
+	if req.TimeoutSeconds < 0 {
+		h.errorJSON(w, "timeout_seconds must be positive", "INVALID_TIMEOUT", http.StatusBadRequest)
+		return
+	}
+	if req.EndTime > 0 && req.StartTime >= req.EndTime {
+		h.errorJSON(w, "start_time must be before end_time", "INVALID_TIME_RANGE", http.StatusBadRequest)
+		return
+	}
+	if req.EndTime > 0 && h.cfg.MaxDuration > 0 && float64(req.EndTime-req.StartTime) > float64(h.cfg.MaxDuration) {
+		h.errorJSON(w, fmt.Sprintf("Clip must be at most %d seconds", h.cfg.MaxDuration), "INVALID_TIME_RANGE", http.StatusBadRequest)
+		return
+	}
+	if req.PreviewSeconds > 0 && h.cfg.PreviewMaxSeconds == 0 {
+		h.errorJSON(w, "Previews are not enabled on this server", "PREVIEW_DISABLED", http.StatusBadRequest)
+		return
+	}
+	if req.PreviewSeconds < 0 || req.PreviewSeconds > h.cfg.PreviewMaxSeconds {
+		h.errorJSON(w, fmt.Sprintf("preview_seconds must be between 1 and %d", h.cfg.PreviewMaxSeconds), "INVALID_PREVIEW", http.StatusBadRequest)
+		return
+	}
+	if !h.validatePlaylist(w, r, &req) {
+		return
+	}
+	var cookies []byte
+	if req.Cookies != "" {
+		if !h.cfg.AllowRequestCookies {
+			h.errorJSON(w, "Request cookies are not enabled on this server", "COOKIES_DISABLED", http.StatusBadRequest)
+			return
+		}
+		var err error
+		cookies, err = base64.StdEncoding.DecodeString(req.Cookies)
+		if err != nil || len(cookies) > maxCookiesSize || bytes.IndexByte(cookies, 0) >= 0 {
+			h.errorJSON(w, "cookies must be a base64 Netscape cookies file", "INVALID_COOKIES", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Proxy != "" {
+		if !h.cfg.AllowRequestProxy {
+			h.errorJSON(w, "Request proxies are not enabled on this server", "PROXY_DISABLED", http.StatusBadRequest)
+			return
+		}
+		if err := h.validateProxy(ctx, req.Proxy); err != nil {
+			h.errorJSON(w, err.Error(), "INVALID_PROXY", http.StatusBadRequest)
+			return
+		}
+	}
+	var rateLimit int64
+	if req.LimitRate != "" {
+		var err error
+		if rateLimit, err = downloader.ParseRate(req.LimitRate); err != nil {
+			h.errorJSON(w, err.Error(), "INVALID_RATE", http.StatusBadRequest)
+			return
+		}
+	}
+	opts := downloader.Options{
+		Media:         req.Format,
+		AudioCodec:    req.AudioCodec,
+		FormatID:      req.FormatID,
+		AudioLanguage: req.AudioLanguage,
+		MaxHeight:     req.MaxHeight,
+		RelaxFormat:   shortForm(req.URL),
+		EmbedChapters: req.EmbedChapters,
+		SponsorRemove: sponsorRemove,
+		SponsorMark:   sponsorMark,
+		ClipStart:     float64(req.StartTime),
+		ClipEnd:       float64(req.EndTime),
+		Cookies:       cookies,
+		Proxy:         req.Proxy,
+		RateLimit:     rateLimit,
+	}
+	if req.TimeoutSeconds > 0 {
+		opts.Timeout = h.jobTimeout(req.TimeoutSeconds)
+	}
+
+	if r.URL.Query().Get("preview") == "true" {
+		h.preview(ctx, w, req.URL, opts)
 		return
 	}
 
-	slog.Info("Download requested", "url", req.URL, "ip", r.RemoteAddr)
+	client := middleware.ClientIP(r)
+	if h.quota.exceeded(client) {
+		h.errorJSON(w, "Download volume quota exceeded, try again later", "BYTES_QUOTA_EXCEEDED", http.StatusTooManyRequests)
+		return
+	}
+	apiKey := middleware.APIKeyID(r.Context())
+	if apiKey != "" && h.keyQuota.exceeded(apiKey) {
+		h.errorJSON(w, "Daily download quota for this API key exceeded", "QUOTA_EXCEEDED", http.StatusTooManyRequests)
+		return
+	}
 
-	// Download video
-	filePath, err := h.dl.Download(ctx, req.URL)
+	slog.Info("Download requested", "url", redact.URL(req.URL), "ip", r.RemoteAddr, "api_key", apiKey)
+
+	var resp DownloadResponse
+	var err error
+	// Downloads with the requester's own cookies may differ per user, so are never shared
+	if req.Playlist {
+		resp, err = h.fetchPlaylist(ctx, req.URL, opts, req.MaxItems, req.URLType)
+	} else if h.cfg.SingleFlight && len(cookies) == 0 {
+		var shared bool
+		key := fmt.Sprintf("%s|%+v|%d|%s", videoKey(req.URL), opts, req.PreviewSeconds, req.URLType)
+		resp, shared, err = h.flight.do(ctx, key, func() (DownloadResponse, error) {
+			return h.fetch(ctx, req.URL, opts, req.PreviewSeconds, req.URLType)
+		})
+		if shared {
+			slog.Info("Reused in-flight download", "url", redact.URL(req.URL))
+			resp.Source = SourceDedup
+		}
+	} else {
+		resp, err = h.fetch(ctx, req.URL, opts, req.PreviewSeconds, req.URLType)
+	}
+	if req.Email != "" || req.CallbackURL != "" {
+		go h.sendNotification(req.Email, req.CallbackURL, req.URL, resp.DownloadURL, err)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.Warn("Download exceeded job deadline", "url", redact.URL(req.URL), "error", err)
+		h.errorJSON(w, "Download took too long and was aborted", "JOB_DEADLINE", http.StatusGatewayTimeout)
+		return
+	}
+	if errors.Is(err, errUpload) {
+		h.errorJSON(w, "Failed to upload video", "UPLOAD_ERROR", http.StatusInternalServerError)
+		return
+	}
 	if err != nil {
-		slog.Error("Download failed", "error", err, "url", req.URL)
-		h.handleDownloadError(w, err)
+		h.handleDownloadError(w, err, h.failureMetadata(r.Context(), req.URL, opts, err))
 		return
 	}
+
+	h.quota.add(client, resp.Filesize)
+	if apiKey != "" {
+		h.keyQuota.add(apiKey, max(1, int64(len(resp.Items))))
+	}
+	slog.Info("Download completed", "url", redact.URL(req.URL), "download_url", redact.URL(resp.DownloadURL))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// minJobTimeout is the shortest deadline a request may ask for. Tests
+// shorten it.
+var minJobTimeout = 10 * time.Second
+
+// jobTimeout clamps a requested timeout between minJobTimeout and MaxJobDuration.
+func (h *Handler) jobTimeout(seconds int) time.Duration {
+	if seconds >= int(h.cfg.MaxJobDuration/time.Second) {
+		return h.cfg.MaxJobDuration
+	}
+	return max(minJobTimeout, time.Duration(seconds)*time.Second)
+}
+
+// failureMetadata returns the video's metadata to include with a failed
+// download with opts, from the info cache or a short lookup. It returns nil
+// when disabled or when the metadata cannot be fetched either.
+func (h *Handler) failureMetadata(ctx context.Context, videoURL string, opts downloader.Options, downloadErr error) *downloader.Format {
+	if !h.cfg.MetadataOnFailure {
+		return nil
+	}
+	if errors.Is(downloadErr, downloader.ErrUpstreamRateLimited) || errors.Is(downloadErr, netguard.ErrForbiddenAddress) {
+		return nil // A lookup would fail the same way
+	}
+	key := infoKey(videoURL, opts)
+	if info, ok := h.cache.Get(key); ok {
+		return info
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+	info, err := h.dl.Resolve(ctx, videoURL, opts)
+	if err != nil {
+		slog.Debug("No metadata for failed download", "error", err, "url", redact.URL(videoURL))
+		return nil
+	}
+	h.cache.Set(key, info, h.cacheTTL(videoURL))
+	return info
+}
+
+// uploadBytesSent counts bytes sent to storage, including uploads in progress.
+var uploadBytesSent = expvar.NewInt("upload_bytes_sent")
+
+// countUpload returns an UploadOptions.Progress adding an upload's progress
+// to uploadBytesSent.
+func countUpload() func(sent, total int64) {
+	var counted int64
+	return func(sent, total int64) {
+		uploadBytesSent.Add(sent - counted)
+		counted = sent
+	}
+}
+
+// fetch downloads a video and uploads it to storage, along with a clip of
+// its first previewSeconds when requested.
+func (h *Handler) fetch(ctx context.Context, videoURL string, opts downloader.Options, previewSeconds int, urlType string) (DownloadResponse, error) {
+	// Download video
+	result, opts, err := h.download(ctx, videoURL, opts)
+	if err != nil {
+		slog.Error("Download failed", "error", err, "url", redact.URL(videoURL))
+		return DownloadResponse{}, err
+	}
+	filePath := result.FilePath
 	defer h.store.Cleanup(filePath)
 
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return DownloadResponse{}, fmt.Errorf("downloaded file not found: %w", err)
+	}
+
+	// The preview clip is fetched while the main file uploads; it is
+	// abandoned if the main upload fails
+	previewCtx, cancelPreview := context.WithCancel(ctx)
+	defer cancelPreview()
+	preview := make(chan string, 1)
+	if previewSeconds > 0 {
+		go func() { preview <- h.fetchPreview(previewCtx, videoURL, opts, previewSeconds, urlType) }()
+	} else {
+		preview <- ""
+	}
+
 	// Upload to storage
-	publicURL, err := h.store.Upload(ctx, filePath)
+	publicURL, err := h.store.Upload(ctx, filePath, storage.UploadOptions{URLType: urlType, Title: result.Title, Progress: countUpload()})
 	if err != nil {
 		slog.Error("Upload failed", "error", err)
-		h.errorJSON(w, "Failed to upload video", "UPLOAD_ERROR", http.StatusInternalServerError)
+		cancelPreview()
+		<-preview
+		return DownloadResponse{}, fmt.Errorf("%w: %v", errUpload, err)
+	}
+
+	resp := downloadResponse(result, info.Size(), publicURL, opts, urlType)
+	resp.PreviewURL = <-preview
+	return resp, nil
+}
+
+// downloadResponse describes a freshly downloaded and uploaded file.
+func downloadResponse(result *downloader.Result, size int64, publicURL string, opts downloader.Options, urlType string) DownloadResponse {
+	return DownloadResponse{
+		DownloadURL:      publicURL,
+		Title:            result.Title,
+		Duration:         result.Duration,
+		Filesize:         size,
+		FileExt:          strings.TrimPrefix(filepath.Ext(result.FilePath), "."),
+		ContentType:      storage.ContentType(result.FilePath),
+		ChaptersEmbedded: opts.EmbedChapters,
+		SponsorsRemoved:  result.SponsorsRemoved,
+		ActualResolution: result.Resolution,
+		ActualVCodec:     result.VCodec,
+		ActualACodec:     result.ACodec,
+		ActualBitrate:    result.Bitrate,
+		Chapters:         result.Chapters,
+		MaxHeight:        opts.MaxHeight,
+		Source:           SourceFresh,
+		URLType:          urlType,
+	}
+}
+
+// retryBackoff is the wait before the first retry of a transient failure;
+// it doubles with each further retry. Tests shorten it.
+var retryBackoff = 2 * time.Second
+
+// download runs the download, stepping down the height cap when the file is
+// too large, relaxing the format selector when no format matches and
+// retrying transient failures with backoff. It returns the options that
+// were finally used.
+func (h *Handler) download(ctx context.Context, videoURL string, opts downloader.Options) (*downloader.Result, downloader.Options, error) {
+	canDowngrade := opts.Media != downloader.MediaAudio && opts.FormatID == ""
+	if canDowngrade && opts.MaxHeight == 0 {
+		opts.MaxHeight = downloader.DefaultMaxHeight
+	}
+
+	downgrades, retries := 0, 0
+	for {
+		result, err := h.platformDownload(ctx, videoURL, opts)
+		switch {
+		case err == nil:
+			return result, opts, nil
+		case errors.Is(err, downloader.ErrFileTooLarge):
+			lower := downloader.LowerHeight(opts.MaxHeight)
+			if !canDowngrade || downgrades >= h.cfg.MaxDowngrades || lower == 0 {
+				return nil, opts, err
+			}
+			downgrades++
+			slog.Info("File too large, retrying at lower quality", "from", opts.MaxHeight, "to", lower, "url", redact.URL(videoURL))
+			opts.MaxHeight = lower
+		case errors.Is(err, downloader.ErrFormatUnavailable) && canDowngrade && !opts.RelaxFormat:
+			// Vertical videos can fail the height cap on every format
+			slog.Info("No format matched, retrying with relaxed selector", "url", redact.URL(videoURL))
+			opts.RelaxFormat = true
+		case downloader.IsRetryable(err) && retries < h.cfg.MaxRetries:
+			wait := retryBackoff << retries
+			retries++
+			slog.Info("Transient download failure, retrying", "attempt", retries, "wait", wait, "error", err, "url", redact.URL(videoURL))
+			select {
+			case <-ctx.Done():
+				return nil, opts, err
+			case <-time.After(wait):
+			}
+		default:
+			return nil, opts, err
+		}
+	}
+}
+
+// platformDownload runs a download once the video's platform has a free
+// slot, waiting for one when the platform is at its concurrency cap.
+func (h *Handler) platformDownload(ctx context.Context, videoURL string, opts downloader.Options) (*downloader.Result, error) {
+	release, err := h.platform.acquire(ctx, platformOf(videoURL))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return h.dl.Download(ctx, videoURL, opts)
+}
+
+// fetchPreview downloads and uploads a clip of the first seconds of a video.
+// Failures are logged and yield an empty URL: the full download still stands.
+func (h *Handler) fetchPreview(ctx context.Context, videoURL string, opts downloader.Options, seconds int, urlType string) string {
+	opts.ClipStart = 0
+	opts.ClipEnd = float64(seconds)
+	opts.MaxFileSize = h.cfg.PreviewMaxFileSize
+
+	result, err := h.platformDownload(ctx, videoURL, opts)
+	if err != nil {
+		slog.Warn("Preview clip failed", "error", err, "url", redact.URL(videoURL))
+		return ""
+	}
+	defer h.store.Cleanup(result.FilePath)
+
+	publicURL, err := h.store.Upload(ctx, result.FilePath, storage.UploadOptions{URLType: urlType, Title: result.Title, Progress: countUpload()})
+	if err != nil {
+		slog.Warn("Preview clip upload failed", "error", err)
+		return ""
+	}
+	return publicURL
+}
+
+// sendNotification notifies the requester about a finished download.
+func (h *Handler) sendNotification(email, callbackURL, videoURL, publicURL string, downloadErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	n := notifier.Notification{Email: email, CallbackURL: callbackURL, VideoURL: videoURL, DownloadURL: publicURL}
+	if downloadErr != nil {
+		n.Error = downloadErr.Error()
+	}
+	if err := h.notify.Notify(ctx, n); err != nil {
+		slog.Warn("Notification failed", "error", err)
+	}
+}
+
+// preview resolves and returns the format a download would fetch.
+func (h *Handler) preview(ctx context.Context, w http.ResponseWriter, videoURL string, opts downloader.Options) {
+	format, err := h.dl.Resolve(ctx, videoURL, opts)
+	if err != nil {
+		slog.Error("Preview failed", "error", err, "url", redact.URL(videoURL))
+		h.handleDownloadError(w, err, nil)
 		return
 	}
 
-	slog.Info("Download completed", "url", req.URL, "download_url", publicURL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(format)
+}
+
+// Info handles GET /api/info?url=..., returning video metadata without
+// downloading. The optional format and max_height parameters, as in a
+// download request, pick the format marked recommended.
+func (h *Handler) Info(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	videoURL := h.prep.Apply(r.URL.Query().Get("url"))
+	if err := h.validateURL(ctx, videoURL); err != nil {
+		h.errorJSON(w, err.Error(), urlErrorCode(err), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := downloader.Options{Media: query.Get("format"), RelaxFormat: shortForm(videoURL)}
+	if opts.Media != "" && opts.Media != downloader.MediaVideo && opts.Media != downloader.MediaAudio {
+		h.errorJSON(w, `format must be "video" or "audio"`, "INVALID_FORMAT", http.StatusBadRequest)
+		return
+	}
+	if s := query.Get("max_height"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || !downloader.IsAllowedHeight(n) {
+			h.errorJSON(w, fmt.Sprintf("max_height must be one of %v", downloader.AllowedHeights), "INVALID_FORMAT", http.StatusBadRequest)
+			return
+		}
+		opts.MaxHeight = n
+	}
+
+	// refresh=true skips the cached entry but still stores the fresh result
+	key := infoKey(videoURL, opts)
+	source := SourceCache
+	info, ok := h.cache.Get(key)
+	if query.Get("refresh") == "true" {
+		ok = false
+	}
+	if !ok {
+		source = SourceFresh
+		var err error
+		info, err = h.dl.Resolve(ctx, videoURL, opts)
+		if err != nil {
+			slog.Error("Info failed", "error", err, "url", redact.URL(videoURL))
+			h.handleDownloadError(w, err, nil)
+			return
+		}
+		h.cache.Set(key, info, h.cacheTTL(videoURL))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InfoResponse{Format: info, Source: source})
+}
+
+// cacheTTL returns the configured info cache TTL for the URL's platform,
+// or 0 to use the cache default.
+func (h *Handler) cacheTTL(videoURL string) time.Duration {
+	parsed, err := url.Parse(videoURL)
+	if err != nil {
+		return 0
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	for domain, ttl := range h.cfg.CacheTTLOverrides {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return ttl
+		}
+	}
+	return 0
+}
+
+// RawInfo handles GET /api/info/raw?url=..., returning yt-dlp's info JSON as-is.
+func (h *Handler) RawInfo(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	videoURL := h.prep.Apply(r.URL.Query().Get("url"))
+	if err := h.validateURL(ctx, videoURL); err != nil {
+		h.errorJSON(w, err.Error(), urlErrorCode(err), http.StatusBadRequest)
+		return
+	}
+
+	raw, err := h.dl.RawInfo(ctx, videoURL)
+	if err != nil {
+		slog.Error("Raw info failed", "error", err, "url", redact.URL(videoURL))
+		h.handleDownloadError(w, err, nil)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(DownloadResponse{DownloadURL: publicURL})
+	w.Write(raw)
 }
 
-// validateURL checks if the URL is valid and from an allowed domain.
-func (h *Handler) validateURL(rawURL string) error {
+// validateURL checks if the URL is valid and from an allowed domain, or,
+// in ModeSSRFOnly, that its host only resolves to public addresses.
+func (h *Handler) validateURL(ctx context.Context, rawURL string) error {
 	if rawURL == "" {
 		return errors.New("URL is required")
 	}
 
+	// Checked first and in every mode: file:, data:, ftp: and other schemes
+	// must never reach yt-dlp, whatever the later checks do
+	if s := urlScheme(rawURL); s != "http" && s != "https" {
+		return errInvalidScheme
+	}
+
+	// Block suspicious patterns (command injection prevention), including
+	// percent-encoded ones that yt-dlp would decode
+	if suspiciousURL(rawURL) {
+		return errors.New("URL contains invalid characters")
+	}
+	if decoded, err := url.PathUnescape(rawURL); err == nil && suspiciousURL(decoded) {
+		return errors.New("URL contains invalid characters")
+	}
+
 	// Basic URL validation
 	parsed, err := url.Parse(rawURL)
 	if err != nil {
 		return errors.New("Invalid URL format")
 	}
 
-	if parsed.Scheme != "http" && parsed.Scheme != "https" {
-		return errors.New("URL must use http or https")
+	if h.cfg.DownloadMode == ModeSSRFOnly {
+		if err := netguard.CheckHost(ctx, h.resolver, parsed.Hostname()); err != nil {
+			slog.Warn("Rejected non-public host", "host", parsed.Hostname(), "error", err)
+			return errors.New("Host is not publicly reachable")
+		}
+	} else {
+		// Check against whitelist
+		host := strings.ToLower(parsed.Host)
+		host = strings.TrimPrefix(host, "www.")
+
+		if !h.allowedHost(host) {
+			return errors.New("Domain not supported")
+		}
 	}
 
-	// Check against whitelist
-	host := strings.ToLower(parsed.Host)
-	host = strings.TrimPrefix(host, "www.")
+	return nil
+}
 
-	allowed := false
-	for _, domain := range allowedDomains {
-		d := strings.TrimPrefix(domain, "www.")
-		if host == d || strings.HasSuffix(host, "."+d) {
-			allowed = true
-			break
-		}
+// errInvalidScheme is returned by validateURL for URLs that are not http(s).
+var errInvalidScheme = errors.New("URL must use http or https")
+
+// urlScheme returns the lowercased scheme of rawURL, or "" if it has none.
+func urlScheme(rawURL string) string {
+	scheme, _, ok := strings.Cut(rawURL, ":")
+	if !ok {
+		return ""
 	}
+	return strings.ToLower(scheme)
+}
 
-	if !allowed {
-		return errors.New("Domain not supported")
+// urlErrorCode returns the error code for a validateURL error.
+func urlErrorCode(err error) string {
+	if errors.Is(err, errInvalidScheme) {
+		return "INVALID_SCHEME"
 	}
+	return "INVALID_URL"
+}
 
-	// Block suspicious patterns (command injection prevention)
-	suspicious := regexp.MustCompile(`[;&|$\x60\\]`)
-	if suspicious.MatchString(rawURL) {
-		return errors.New("URL contains invalid characters")
+// suspiciousPattern matches shell metacharacters, control characters and
+// whitespace, none of which belong in a video URL.
+var suspiciousPattern = regexp.MustCompile(`[;&|$\x60\\\x00-\x20\x7f]`)
+
+// suspiciousURL reports whether s contains characters that could smuggle
+// extra arguments or commands through to yt-dlp.
+func suspiciousURL(s string) bool {
+	return suspiciousPattern.MatchString(s) || strings.ContainsFunc(s, unicode.IsSpace)
+}
+
+// validateCallbackURL checks that a callback URL is absolute http(s) and
+// only resolves to public addresses. Delivery re-checks at connect time.
+func (h *Handler) validateCallbackURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return errors.New("callback_url must be an absolute http or https URL")
+	}
+	if parsed.User != nil {
+		return errors.New("callback_url must not contain credentials")
 	}
+	if err := netguard.CheckHost(ctx, h.resolver, parsed.Hostname()); err != nil {
+		slog.Warn("Rejected callback host", "host", parsed.Hostname(), "error", err)
+		return errors.New("callback_url host is not publicly reachable")
+	}
+	return nil
+}
 
+// validateProxy checks a request proxy URL and that its host is publicly
+// reachable, so requests cannot reach internal services through yt-dlp.
+func (h *Handler) validateProxy(ctx context.Context, rawURL string) error {
+	if err := downloader.ValidateProxy(rawURL); err != nil {
+		return err
+	}
+	parsed, _ := url.Parse(rawURL)
+	if err := netguard.CheckHost(ctx, h.resolver, parsed.Hostname()); err != nil {
+		slog.Warn("Rejected proxy host", "host", parsed.Hostname(), "error", err)
+		return errors.New("proxy host is not publicly reachable")
+	}
 	return nil
 }
 
 // handleDownloadError maps download errors to appropriate HTTP responses.
-func (h *Handler) handleDownloadError(w http.ResponseWriter, err error) {
+// video, when known, is included so clients still get the metadata.
+func (h *Handler) handleDownloadError(w http.ResponseWriter, err error, video *downloader.Format) {
+	message, code, status := h.downloadErrorStatus(err)
+	if code == "UPSTREAM_RATE_LIMITED" {
+		w.Header().Set("Retry-After", upstreamRetryAfter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code, Video: video})
+}
+
+// downloadErrorStatus returns the client message, error code and HTTP
+// status for a download error.
+func (h *Handler) downloadErrorStatus(err error) (message, code string, status int) {
 	msg := err.Error()
 
 	switch {
+	case errors.Is(err, netguard.ErrForbiddenAddress):
+		return "Host is not publicly reachable", "INVALID_URL", http.StatusBadRequest
+	case errors.Is(err, downloader.ErrUpstreamRateLimited):
+		return "Video platform is rate limiting downloads, try again later", "UPSTREAM_RATE_LIMITED", http.StatusServiceUnavailable
+	case errors.Is(err, downloader.ErrNoVideoFormats):
+		return "No video found at this URL", "NO_MEDIA_FOUND", http.StatusUnprocessableEntity
+	case errors.Is(err, downloader.ErrFFmpegRequired):
+		return "This option is not available on this server", "FEATURE_UNAVAILABLE", http.StatusNotImplemented
+	case errors.Is(err, downloader.ErrClipUnsupported):
+		return "This video cannot be downloaded as a clip", "CLIP_UNSUPPORTED", http.StatusUnprocessableEntity
+	case errors.Is(err, downloader.ErrEmptyPlaylist):
+		return "No downloadable entries in this playlist", "NO_MEDIA_FOUND", http.StatusUnprocessableEntity
+	case errors.Is(err, downloader.ErrPlaylistTooLong):
+		return "Playlist exceeds maximum total duration", "DURATION_EXCEEDED", http.StatusBadRequest
+	case errors.Is(err, downloader.ErrFormatUnavailable):
+		return "Requested format or audio track is not available", "FORMAT_UNAVAILABLE", http.StatusUnprocessableEntity
 	case strings.Contains(msg, "duration"):
-		h.errorJSON(w, "Video exceeds maximum duration (30 minutes)", "DURATION_EXCEEDED", http.StatusBadRequest)
-	case strings.Contains(msg, "filesize") || strings.Contains(msg, "file size"):
-		h.errorJSON(w, "Video exceeds maximum file size (500MB)", "SIZE_EXCEEDED", http.StatusBadRequest)
+		return "Video exceeds maximum duration" + durationLimit(h.cfg.MaxDuration), "DURATION_EXCEEDED", http.StatusBadRequest
+	case errors.Is(err, downloader.ErrFileTooLarge), strings.Contains(msg, "filesize"), strings.Contains(msg, "file size"):
+		return "Video exceeds maximum file size" + sizeLimit(h.cfg.MaxFileSize), "SIZE_EXCEEDED", http.StatusBadRequest
 	case strings.Contains(msg, "unavailable") || strings.Contains(msg, "private"):
-		h.errorJSON(w, "Video is unavailable or private", "VIDEO_UNAVAILABLE", http.StatusNotFound)
+		return "Video is unavailable or private", "VIDEO_UNAVAILABLE", http.StatusNotFound
 	case strings.Contains(msg, "timed out"):
-		h.errorJSON(w, "Download timed out", "TIMEOUT", http.StatusGatewayTimeout)
+		return "Download timed out", "TIMEOUT", http.StatusGatewayTimeout
 	default:
-		h.errorJSON(w, "Failed to download video", "DOWNLOAD_ERROR", http.StatusInternalServerError)
+		return "Failed to download video", "DOWNLOAD_ERROR", http.StatusInternalServerError
+	}
+}
+
+// durationLimit formats a duration limit in seconds for an error message,
+// e.g. " (30 minutes)". It is empty when no limit is set.
+func durationLimit(seconds int) string {
+	switch {
+	case seconds <= 0:
+		return ""
+	case seconds%60 == 0:
+		return fmt.Sprintf(" (%d minutes)", seconds/60)
+	default:
+		return fmt.Sprintf(" (%d seconds)", seconds)
+	}
+}
+
+// sizeLimit formats a size limit in bytes for an error message, e.g.
+// " (500MB)". It is empty when no limit is set.
+func sizeLimit(bytes int64) string {
+	if bytes <= 0 {
+		return ""
 	}
+	return fmt.Sprintf(" (%dMB)", bytes/(1024*1024))
 }
 
 // errorJSON writes a JSON error response.
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+	"github.com/emanuelef/yt-dl-api-go/internal/netguard"
+)
+
+// postDownload sends body to the Download handler and decodes the response into out.
+func postDownload(t *testing.T, h *Handler, body string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/download", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.Download(rec, req)
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("decoding response %d: %v", rec.Code, err)
+		}
+	}
+	return rec
+}
+
+func TestDownloadHappyPath(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+
+	var resp DownloadResponse
+	rec := postDownload(t, f.h, `{"url":"https://www.youtube.com/watch?v=abc123","format_id":"22"}`, &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	key := strings.TrimPrefix(resp.DownloadURL, "https://storage.test/")
+	data, ok := f.store.object(key)
+	if !ok || string(data) != fakeContent {
+		t.Fatalf("uploaded object %q = %q, %v", key, data, ok)
+	}
+	if title := f.store.titles[key]; title != "Title video" {
+		t.Errorf("upload title = %q, want the video title", title)
+	}
+	if resp.FileExt != "mp4" || resp.ContentType != "video/mp4" || resp.Filesize != int64(len(fakeContent)) ||
+		resp.Title != "Title video" || resp.Duration != fakeDuration || resp.Source != SourceFresh {
+		t.Errorf("response = %+v", resp)
+	}
+	if len(f.dl.calls) != 1 || f.dl.calls[0].FormatID != "22" {
+		t.Errorf("downloader calls = %+v", f.dl.calls)
+	}
+
+	// The local copy is removed once uploaded
+	entries, _ := os.ReadDir(f.dl.dir)
+	if len(entries) != 0 {
+		t.Errorf("temp dir still has %d files", len(entries))
+	}
+}
+
+func TestDownloadErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		dlErr      error
+		storeErr   error
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid json", `{`, nil, nil, http.StatusBadRequest, "INVALID_JSON"},
+		{"domain not allowed", `{"url":"https://example.com/v"}`, nil, nil, http.StatusBadRequest, "INVALID_URL"},
+		{"file scheme", `{"url":"file:///etc/passwd"}`, nil, nil, http.StatusBadRequest, "INVALID_SCHEME"},
+		{"bad format id", `{"url":"https://youtu.be/abc","format_id":"22 --exec"}`, nil, nil, http.StatusBadRequest, "INVALID_FORMAT"},
+		{"too large", `{"url":"https://youtu.be/abc"}`, errors.New("video exceeds maximum file size limit"), nil, http.StatusBadRequest, "SIZE_EXCEEDED"},
+		{"private host", `{"url":"https://youtu.be/abc"}`, netguard.ErrForbiddenAddress, nil, http.StatusBadRequest, "INVALID_URL"},
+		{"rate limited", `{"url":"https://youtu.be/abc"}`, downloader.ErrUpstreamRateLimited, nil, http.StatusServiceUnavailable, "UPSTREAM_RATE_LIMITED"},
+		{"upload fails", `{"url":"https://youtu.be/abc"}`, nil, errors.New("bucket gone"), http.StatusInternalServerError, "UPLOAD_ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{})
+			f.dl.err = tt.dlErr
+			f.store.err = tt.storeErr
+
+			var resp ErrorResponse
+			rec := postDownload(t, f.h, tt.body, &resp)
+			if rec.Code != tt.wantStatus || resp.Code != tt.wantCode {
+				t.Errorf("got %d %s, want %d %s", rec.Code, resp.Code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestDownloadLimitMessages(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		dlErr error
+		want  string
+	}{
+		{"duration", Config{MaxDuration: 600}, errors.New("video duration too long"), "Video exceeds maximum duration (10 minutes)"},
+		{"duration seconds", Config{MaxDuration: 90}, errors.New("video duration too long"), "Video exceeds maximum duration (90 seconds)"},
+		{"size", Config{MaxFileSize: 200 * 1024 * 1024}, downloader.ErrFileTooLarge, "Video exceeds maximum file size (200MB)"},
+		{"no limit", Config{}, downloader.ErrFileTooLarge, "Video exceeds maximum file size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, tt.cfg)
+			f.dl.err = tt.dlErr
+
+			var resp ErrorResponse
+			postDownload(t, f.h, `{"url":"https://youtu.be/abc"}`, &resp)
+			if resp.Error != tt.want {
+				t.Errorf("error = %q, want %q", resp.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSeconds int
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"within limit", 30, `{"url":"https://youtu.be/abc","preview_seconds":10}`, http.StatusOK, ""},
+		{"over limit", 30, `{"url":"https://youtu.be/abc","preview_seconds":60}`, http.StatusBadRequest, "INVALID_PREVIEW"},
+		{"negative", 30, `{"url":"https://youtu.be/abc","preview_seconds":-1}`, http.StatusBadRequest, "INVALID_PREVIEW"},
+		{"disabled", 0, `{"url":"https://youtu.be/abc","preview_seconds":10}`, http.StatusBadRequest, "PREVIEW_DISABLED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{PreviewMaxSeconds: tt.maxSeconds})
+
+			var resp ErrorResponse
+			rec := postDownload(t, f.h, tt.body, &resp)
+			if rec.Code != tt.wantStatus || resp.Code != tt.wantCode {
+				t.Errorf("got %d %s, want %d %s", rec.Code, resp.Code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = 2 * time.Second })
+
+	transient := fmt.Errorf("%w: HTTP Error 503", downloader.ErrTransient)
+	tests := []struct {
+		name       string
+		maxRetries int
+		failures   []error
+		wantStatus int
+		wantCalls  int
+	}{
+		{"recovers", 2, []error{transient, downloader.ErrUpstreamRateLimited}, http.StatusOK, 3},
+		{"gives up", 1, []error{transient, transient}, http.StatusInternalServerError, 2},
+		{"disabled", 0, []error{transient}, http.StatusInternalServerError, 1},
+		{"not retryable", 2, []error{errors.New("Video unavailable")}, http.StatusNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{MaxRetries: tt.maxRetries})
+			f.dl.failures = tt.failures
+
+			rec := postDownload(t, f.h, `{"url":"https://youtu.be/abc"}`, nil)
+			if rec.Code != tt.wantStatus || f.dl.callCount() != tt.wantCalls {
+				t.Errorf("status %d after %d calls, want %d after %d", rec.Code, f.dl.callCount(), tt.wantStatus, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	minJobTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { minJobTimeout = 10 * time.Second })
+
+	tests := []struct {
+		name           string
+		maxJobDuration time.Duration
+		body           string
+		wantTimeout    time.Duration
+		wantCode       string
+	}{
+		{"client timeout", time.Minute, `{"url":"https://youtu.be/abc","timeout_seconds":1}`, time.Second, "TIMEOUT"},
+		{"server deadline", 200 * time.Millisecond, `{"url":"https://youtu.be/abc"}`, 0, "JOB_DEADLINE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{MaxJobDuration: tt.maxJobDuration})
+			f.dl.block = make(chan struct{})
+			defer close(f.dl.block)
+
+			var resp ErrorResponse
+			rec := postDownload(t, f.h, tt.body, &resp)
+			if rec.Code != http.StatusGatewayTimeout || resp.Code != tt.wantCode {
+				t.Errorf("got %d %s, want 504 %s", rec.Code, resp.Code, tt.wantCode)
+			}
+			if len(f.dl.calls) != 1 || f.dl.calls[0].Timeout != tt.wantTimeout {
+				t.Errorf("download calls = %+v, want one with timeout %v", f.dl.calls, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestSponsorBlockOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantRemove []string
+		wantMark   []string
+	}{
+		{"remove", `{"url":"https://youtu.be/abc","sponsorblock":"remove"}`, []string{"intro"}, nil},
+		{"mark", `{"url":"https://youtu.be/abc","sponsorblock":"mark","sponsor_categories":["sponsor","outro"]}`, nil, []string{"sponsor", "outro"}},
+		{"remove_sponsors", `{"url":"https://youtu.be/abc","remove_sponsors":true}`, []string{"intro"}, nil},
+		{"not youtube", `{"url":"https://vimeo.com/1","sponsorblock":"mark"}`, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{SponsorCategories: []string{"intro"}})
+			rec := postDownload(t, f.h, tt.body, nil)
+			if rec.Code != http.StatusOK || len(f.dl.calls) != 1 {
+				t.Fatalf("status %d after %d calls: %s", rec.Code, len(f.dl.calls), rec.Body)
+			}
+			opts := f.dl.calls[0]
+			if !slices.Equal(opts.SponsorRemove, tt.wantRemove) || !slices.Equal(opts.SponsorMark, tt.wantMark) {
+				t.Errorf("remove %q, mark %q; want %q, %q", opts.SponsorRemove, opts.SponsorMark, tt.wantRemove, tt.wantMark)
+			}
+		})
+	}
+}
+
+func TestSponsorBlockInvalid(t *testing.T) {
+	for _, body := range []string{
+		`{"url":"https://youtu.be/abc","sponsorblock":"skip"}`,
+		`{"url":"https://youtu.be/abc","sponsorblock":"mark","remove_sponsors":true}`,
+		`{"url":"https://youtu.be/abc","sponsor_categories":["sponsor"]}`,
+		`{"url":"https://youtu.be/abc","sponsorblock":"remove","sponsor_categories":["ads"]}`,
+	} {
+		f := newFakeHandler(t, Config{})
+		var resp ErrorResponse
+		rec := postDownload(t, f.h, body, &resp)
+		if rec.Code != http.StatusBadRequest || len(f.dl.calls) != 0 {
+			t.Errorf("%s: status %d %s after %d calls, want 400", body, rec.Code, resp.Code, len(f.dl.calls))
+		}
+	}
+}
+
+func TestFailureMetadataUsesRequestOptions(t *testing.T) {
+	f := newFakeHandler(t, Config{MetadataOnFailure: true})
+	f.dl.failures = []error{errors.New("Video unavailable")}
+
+	var resp ErrorResponse
+	postDownload(t, f.h, `{"url":"https://youtu.be/abc","format":"audio"}`, &resp)
+	if resp.Video == nil || len(f.dl.calls) != 2 {
+		t.Fatalf("video = %+v after %d calls, want metadata from a lookup", resp.Video, len(f.dl.calls))
+	}
+	if lookup := f.dl.calls[1]; lookup.Media != downloader.MediaAudio {
+		t.Errorf("lookup options = %+v, want the request's", lookup)
+	}
+	if _, ok := f.cache.Get("youtube:abc|audio"); !ok {
+		t.Error("metadata not cached under the request's quality")
+	}
+
+	// The cached entry for the default quality is not reused for audio
+	f.cache.Set("youtube:abc", &downloader.Format{FormatID: "22"}, 0)
+	f.cache.Set("youtube:abc|audio", &downloader.Format{FormatID: "140"}, 0)
+	f.dl.failures = []error{errors.New("Video unavailable")}
+	postDownload(t, f.h, `{"url":"https://youtu.be/abc","format":"audio"}`, &resp)
+	if resp.Video == nil || resp.Video.FormatID != "140" || len(f.dl.calls) != 3 {
+		t.Errorf("video = %+v after %d calls, want the cached audio entry", resp.Video, len(f.dl.calls))
+	}
+}
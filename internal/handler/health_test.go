@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   map[string]string
+	}{
+		{"", map[string]string{"status": "ok"}},
+		{"R2 unreachable, using local storage", map[string]string{"status": "degraded", "reason": "R2 unreachable, using local storage"}},
+	}
+	for _, tt := range tests {
+		f := newFakeHandler(t, Config{DegradedReason: tt.reason})
+		rec := httptest.NewRecorder()
+		f.h.Health(rec, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+
+		var got map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.want) || got["status"] != tt.want["status"] || got["reason"] != tt.want["reason"] {
+			t.Errorf("Health = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		dlErr      error
+		storeErr   error
+		wantStatus int
+		want       map[string]string
+	}{
+		{"all ok", nil, nil, http.StatusOK, map[string]string{"ytdlp": "ok", "storage": "ok"}},
+		{"yt-dlp missing", errors.New("not found"), nil, http.StatusServiceUnavailable, map[string]string{"ytdlp": "fail", "storage": "ok"}},
+		{"storage down", nil, errors.New("forbidden"), http.StatusServiceUnavailable, map[string]string{"ytdlp": "ok", "storage": "fail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{})
+			f.dl.checkErr = tt.dlErr
+			f.store.checkErr = tt.storeErr
+			rec := httptest.NewRecorder()
+			f.h.Ready(rec, httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+
+			var got map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantStatus || len(got) != len(tt.want) || got["ytdlp"] != tt.want["ytdlp"] || got["storage"] != tt.want["storage"] {
+				t.Errorf("Ready = %d %v, want %d %v", rec.Code, got, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadyCached(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		f.h.Ready(rec, httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+		return rec.Code
+	}
+
+	ready()
+	f.dl.checkErr = errors.New("not found")
+	if code := ready(); code != http.StatusOK || f.dl.checks != 1 {
+		t.Errorf("second call = %d after %d checks, want the cached 200 after 1", code, f.dl.checks)
+	}
+
+	// Once the result expires the checks run again
+	f.h.ready.checked = time.Now().Add(-readyCacheTTL)
+	if code := ready(); code != http.StatusServiceUnavailable || f.dl.checks != 2 {
+		t.Errorf("after expiry = %d after %d checks, want 503 after 2", code, f.dl.checks)
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+)
+
+// getInfo requests /api/info with query.
+func getInfo(h *Handler, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/info?"+query, nil)
+	rec := httptest.NewRecorder()
+	h.Info(rec, req)
+	return rec
+}
+
+func TestInfoSource(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+
+	for _, want := range []string{SourceFresh, SourceCache} {
+		rec := getInfo(f.h, "url=https://youtu.be/abc")
+		var resp InfoResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status %d, %v", rec.Code, err)
+		}
+		if resp.Source != want || resp.Format == nil || resp.FormatID != "22" {
+			t.Errorf("response = %+v, want source %s", resp, want)
+		}
+	}
+	if len(f.dl.calls) != 1 {
+		t.Errorf("Resolve calls = %d, want 1", len(f.dl.calls))
+	}
+}
+
+func TestInfoRefresh(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+
+	getInfo(f.h, "url=https://youtu.be/abc")
+	cached, _ := f.cache.Get("youtube:abc")
+
+	var resp InfoResponse
+	rec := getInfo(f.h, "url=https://youtu.be/abc&refresh=true")
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, %v", rec.Code, err)
+	}
+	if resp.Source != SourceFresh || len(f.dl.calls) != 2 {
+		t.Errorf("refresh: source %s, Resolve calls %d; want %s, 2", resp.Source, len(f.dl.calls), SourceFresh)
+	}
+	if refreshed, _ := f.cache.Get("youtube:abc"); refreshed == cached {
+		t.Error("refresh did not update the cached entry")
+	}
+
+	// The refreshed entry serves later requests
+	rec = getInfo(f.h, "url=https://youtu.be/abc")
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Source != SourceCache || len(f.dl.calls) != 2 {
+		t.Errorf("after refresh: source %s, Resolve calls %d, %v", resp.Source, len(f.dl.calls), err)
+	}
+}
+
+func TestInfoCacheTTLOverrides(t *testing.T) {
+	f := newFakeHandler(t, Config{CacheTTLOverrides: map[string]time.Duration{"twitch.tv": time.Minute}})
+
+	getInfo(f.h, "url=https://www.twitch.tv/videos/1")
+	getInfo(f.h, "url=https://clips.twitch.tv/abc")
+	getInfo(f.h, "url=https://youtu.be/abc")
+	for key, want := range map[string]time.Duration{
+		"twitch.tv/videos/1":  time.Minute,
+		"clips.twitch.tv/abc": time.Minute,
+		"youtube:abc":         0,
+	} {
+		if got, ok := f.cache.ttls[key]; !ok || got != want {
+			t.Errorf("TTL for %s = %v (stored %v), want %v", key, got, ok, want)
+		}
+	}
+}
+
+func TestInfoPassesQuality(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+
+	rec := getInfo(f.h, "url=https://youtu.be/abc&format=audio&max_height=720")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if len(f.dl.calls) != 1 {
+		t.Fatalf("Resolve calls = %d, want 1", len(f.dl.calls))
+	}
+	if got := f.dl.calls[0]; got.Media != downloader.MediaAudio || got.MaxHeight != 720 {
+		t.Errorf("Resolve options = %+v", got)
+	}
+}
+
+func TestInfoCachePerQuality(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+
+	for _, query := range []string{
+		"url=https://youtu.be/abc",
+		"url=https://youtu.be/abc&max_height=1080", // the default quality
+		"url=https://youtu.be/abc&max_height=480",
+		"url=https://www.youtube.com/watch?v=abc&max_height=480",
+	} {
+		if rec := getInfo(f.h, query); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", query, rec.Code)
+		}
+	}
+	if len(f.dl.calls) != 2 {
+		t.Errorf("Resolve calls = %d, want 2 (one per quality)", len(f.dl.calls))
+	}
+}
+
+func TestInfoInvalidQuality(t *testing.T) {
+	for _, query := range []string{
+		"url=https://youtu.be/abc&max_height=721",
+		"url=https://youtu.be/abc&max_height=high",
+		"url=https://youtu.be/abc&format=gif",
+	} {
+		f := newFakeHandler(t, Config{})
+		rec := getInfo(f.h, query)
+		if rec.Code != http.StatusBadRequest || len(f.dl.calls) != 0 {
+			t.Errorf("%s: status = %d, Resolve calls = %d", query, rec.Code, len(f.dl.calls))
+		}
+	}
+}
+
+func TestPreviewPassesOptions(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/download?preview=true",
+		strings.NewReader(`{"url":"https://youtu.be/abc","format":"audio","max_height":480}`))
+	rec := httptest.NewRecorder()
+	f.h.Download(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if len(f.dl.calls) != 1 || f.dl.calls[0].Media != downloader.MediaAudio || f.dl.calls[0].MaxHeight != 480 {
+		t.Errorf("Resolve calls = %+v", f.dl.calls)
+	}
+}
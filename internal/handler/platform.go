@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"expvar"
+	"net/url"
+	"strings"
+)
+
+// platformsInFlight counts running downloads per platform. Platforms
+// outside knownPlatforms and the configured caps share otherPlatform, so
+// arbitrary hosts cannot grow the map.
+var platformsInFlight = expvar.NewMap("platform_downloads_in_flight")
+
+// otherPlatform is the platformsInFlight key of unlisted platforms.
+const otherPlatform = "other"
+
+// knownPlatforms are the platforms counted under their own name.
+var knownPlatforms = map[string]bool{
+	"youtube":     true,
+	"twitter":     true,
+	"facebook":    true,
+	"instagram":   true,
+	"tiktok":      true,
+	"reddit":      true,
+	"vimeo":       true,
+	"twitch":      true,
+	"dailymotion": true,
+	"soundcloud":  true,
+}
+
+// platformHosts maps hosts (without "www.") to the platform they belong to,
+// so every domain of a platform shares one concurrency cap.
+var platformHosts = map[string]string{
+	"youtu.be":             "youtube",
+	"youtube-nocookie.com": "youtube",
+	"youtubekids.com":      "youtube",
+	"twitter.com":          "twitter",
+	"x.com":                "twitter",
+	"fb.watch":             "facebook",
+	"redd.it":              "reddit",
+}
+
+// platformOf returns the platform name for videoURL: the registrable name
+// of its host ("youtube" for youtube.com and youtube.co.uk), after mapping
+// known aliases through platformHosts.
+func platformOf(videoURL string) string {
+	parsed, err := url.Parse(videoURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	for h := host; h != ""; {
+		if p, ok := platformHosts[h]; ok {
+			return p
+		}
+		_, rest, ok := strings.Cut(h, ".")
+		if !ok {
+			break
+		}
+		h = rest
+	}
+
+	labels := strings.Split(host, ".")
+	// Drop the TLD and a second-level "co"/"com" (youtube.co.uk)
+	i := len(labels) - 2
+	if i > 0 && (labels[i] == "co" || labels[i] == "com") {
+		i--
+	}
+	if i < 0 {
+		return host
+	}
+	return labels[i]
+}
+
+// shortFormPlatforms are platforms serving mostly vertical videos, where the
+// default selector's height cap and mp4 preference often exclude every format.
+var shortFormPlatforms = map[string]bool{
+	"tiktok":    true,
+	"instagram": true,
+}
+
+// shortForm reports whether videoURL is short-form vertical content that
+// should use the relaxed format selector.
+func shortForm(videoURL string) bool {
+	platform := platformOf(videoURL)
+	if shortFormPlatforms[platform] {
+		return true
+	}
+	parsed, err := url.Parse(videoURL)
+	return err == nil && platform == "youtube" && strings.HasPrefix(parsed.Path, "/shorts/")
+}
+
+// platformLimiter caps simultaneous downloads per platform. Platforms
+// without a configured cap are unlimited.
+type platformLimiter struct {
+	slots map[string]chan struct{}
+}
+
+func newPlatformLimiter(caps map[string]int) *platformLimiter {
+	slots := make(map[string]chan struct{}, len(caps))
+	for platform, n := range caps {
+		if n > 0 {
+			slots[platform] = make(chan struct{}, n)
+		}
+	}
+	return &platformLimiter{slots: slots}
+}
+
+// metricKey returns the platformsInFlight key counting platform.
+func (l *platformLimiter) metricKey(platform string) string {
+	if _, capped := l.slots[platform]; capped || knownPlatforms[platform] {
+		return platform
+	}
+	return otherPlatform
+}
+
+// acquire waits for a download slot on platform and returns its release
+// function, or ctx's error if ctx ends first.
+func (l *platformLimiter) acquire(ctx context.Context, platform string) (func(), error) {
+	if slot, ok := l.slots[platform]; ok {
+		select {
+		case slot <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	key := l.metricKey(platform)
+	platformsInFlight.Add(key, 1)
+	return func() {
+		platformsInFlight.Add(key, -1)
+		if slot, ok := l.slots[platform]; ok {
+			<-slot
+		}
+	}, nil
+}
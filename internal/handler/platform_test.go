@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+)
+
+func TestPlatformOf(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://www.youtube.com/watch?v=abc", "youtube"},
+		{"https://m.youtube.co.uk/watch?v=abc", "youtube"},
+		{"https://youtu.be/abc", "youtube"},
+		{"https://x.com/user/status/1", "twitter"},
+		{"https://vm.tiktok.com/abc", "tiktok"},
+		{"https://video.example.org/v/1", "example"},
+		{"https://localhost/v", "localhost"},
+	}
+	for _, tt := range tests {
+		if got := platformOf(tt.url); got != tt.want {
+			t.Errorf("platformOf(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformMetricKey(t *testing.T) {
+	l := newPlatformLimiter(map[string]int{"example": 1})
+	tests := []struct {
+		platform, want string
+	}{
+		{"youtube", "youtube"},
+		{"example", "example"},
+		{"random-host-123", otherPlatform},
+		{"", otherPlatform},
+	}
+	for _, tt := range tests {
+		if got := l.metricKey(tt.platform); got != tt.want {
+			t.Errorf("metricKey(%q) = %q, want %q", tt.platform, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformAcquireUnknownHost(t *testing.T) {
+	l := newPlatformLimiter(nil)
+	release, err := l.acquire(context.Background(), "unlisted-platform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if platformsInFlight.Get("unlisted-platform") != nil {
+		t.Error("unlisted platform got its own metric key")
+	}
+	if platformsInFlight.Get(otherPlatform) == nil {
+		t.Errorf("%q key not counted", otherPlatform)
+	}
+}
+
+func TestPlatformConcurrency(t *testing.T) {
+	f := newFakeHandler(t, Config{PlatformConcurrency: map[string]int{"youtube": 2}})
+	f.dl.block = make(chan struct{})
+
+	// Four downloads of different videos from one platform
+	urls := []string{
+		"https://www.youtube.com/watch?v=one",
+		"https://youtu.be/two",
+		"https://www.youtube.com/watch?v=three",
+		"https://m.youtube.com/watch?v=four",
+	}
+	var wg sync.WaitGroup
+	codes := make([]int, len(urls))
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = postDownload(t, f.h, `{"url":"`+u+`"}`, nil).Code
+		}()
+	}
+
+	// Only two reach the downloader while the others wait for a slot
+	for f.dl.callCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := f.dl.callCount(); n != 2 {
+		t.Errorf("downloads running = %d, want 2", n)
+	}
+
+	close(f.dl.block)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("%s: status %d", urls[i], code)
+		}
+	}
+	if n := f.dl.callCount(); n != len(urls) {
+		t.Errorf("downloads = %d, want %d", n, len(urls))
+	}
+}
+
+func TestVerticalVideoFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		wantCalls []bool // RelaxFormat of each download
+	}{
+		{"strict, then relaxed", "https://www.youtube.com/watch?v=abc", []bool{false, true}},
+		{"youtube shorts", "https://www.youtube.com/shorts/abc", []bool{true}},
+		{"tiktok", "https://www.tiktok.com/@user/video/1", []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{})
+			f.dl.strictErr = downloader.ErrFormatUnavailable
+
+			rec := postDownload(t, f.h, `{"url":"`+tt.url+`"}`, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got []bool
+			for _, opts := range f.dl.calls {
+				got = append(got, opts.RelaxFormat)
+			}
+			if !slices.Equal(got, tt.wantCalls) {
+				t.Errorf("RelaxFormat per download = %v, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+	"github.com/emanuelef/yt-dl-api-go/internal/redact"
+	"github.com/emanuelef/yt-dl-api-go/internal/storage"
+)
+
+// validatePlaylist checks the playlist options of req, defaulting MaxItems
+// to the server's cap. It writes the error response and returns false when
+// they are invalid.
+func (h *Handler) validatePlaylist(w http.ResponseWriter, r *http.Request, req *DownloadRequest) bool {
+	if !req.Playlist {
+		if req.MaxItems != 0 {
+			h.errorJSON(w, "max_items requires playlist", "INVALID_MAX_ITEMS", http.StatusBadRequest)
+			return false
+		}
+		return true
+	}
+	if h.cfg.PlaylistMaxItems <= 0 {
+		h.errorJSON(w, "Playlist downloads are not enabled on this server", "PLAYLIST_DISABLED", http.StatusBadRequest)
+		return false
+	}
+	if req.MaxItems < 0 || req.MaxItems > h.cfg.PlaylistMaxItems {
+		h.errorJSON(w, fmt.Sprintf("max_items must be between 1 and %d", h.cfg.PlaylistMaxItems), "INVALID_MAX_ITEMS", http.StatusBadRequest)
+		return false
+	}
+	if req.MaxItems == 0 {
+		req.MaxItems = h.cfg.PlaylistMaxItems
+	}
+	if req.FormatID != "" || req.StartTime > 0 || req.EndTime > 0 || req.PreviewSeconds > 0 ||
+		req.Email != "" || req.CallbackURL != "" || r.URL.Query().Get("preview") == "true" {
+		h.errorJSON(w, "Playlist downloads do not support format_id, clips, previews or notifications", "INVALID_PLAYLIST", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// fetchPlaylist downloads up to maxItems entries of a playlist and uploads
+// them, up to PlaylistUploadConcurrency at once. A failed upload fails the
+// whole request, cancelling the others, unless PlaylistPartialResults is set;
+// entries already uploaded are left to the storage's expiry.
+func (h *Handler) fetchPlaylist(ctx context.Context, playlistURL string, opts downloader.Options, maxItems int, urlType string) (DownloadResponse, error) {
+	release, err := h.platform.acquire(ctx, platformOf(playlistURL))
+	if err != nil {
+		return DownloadResponse{}, err
+	}
+	results, err := h.dl.DownloadPlaylist(ctx, playlistURL, opts, maxItems)
+	release()
+	if err != nil {
+		slog.Error("Playlist download failed", "error", err, "url", redact.URL(playlistURL))
+		return DownloadResponse{}, err
+	}
+	for _, result := range results {
+		defer h.store.Cleanup(result.FilePath)
+	}
+
+	resp := DownloadResponse{
+		ChaptersEmbedded: opts.EmbedChapters,
+		MaxHeight:        opts.MaxHeight,
+		Source:           SourceFresh,
+		URLType:          urlType,
+		Items:            make([]DownloadResponse, len(results)),
+	}
+	errs := make([]error, len(results))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(h.cfg.PlaylistUploadConcurrency, 1))
+	for i, result := range results {
+		g.Go(func() error {
+			item, err := h.uploadEntry(gctx, result, opts, urlType)
+			if err != nil && !h.cfg.PlaylistPartialResults {
+				return err
+			}
+			resp.Items[i], errs[i] = item, err
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return DownloadResponse{}, err
+	}
+
+	uploaded := 0
+	for i, item := range resp.Items {
+		if errs[i] != nil {
+			resp.Items[i] = DownloadResponse{Title: results[i].Title, Source: SourceFresh, URLType: urlType, Error: uploadErrorMessage}
+			continue
+		}
+		uploaded++
+		resp.Duration += item.Duration
+		resp.Filesize += item.Filesize
+	}
+	if uploaded == 0 {
+		return DownloadResponse{}, errors.Join(errs...)
+	}
+	slog.Info("Playlist downloaded", "url", redact.URL(playlistURL), "items", len(resp.Items), "failed", len(resp.Items)-uploaded)
+	return resp, nil
+}
+
+// uploadErrorMessage is the Error of playlist entries that failed to upload.
+const uploadErrorMessage = "Failed to upload video"
+
+// uploadEntry uploads one downloaded playlist entry and returns its item.
+func (h *Handler) uploadEntry(ctx context.Context, result *downloader.Result, opts downloader.Options, urlType string) (DownloadResponse, error) {
+	info, err := os.Stat(result.FilePath)
+	if err != nil {
+		return DownloadResponse{}, fmt.Errorf("downloaded file not found: %w", err)
+	}
+	publicURL, err := h.store.Upload(ctx, result.FilePath, storage.UploadOptions{URLType: urlType, Title: result.Title, Progress: countUpload()})
+	if err != nil {
+		slog.Error("Upload failed", "error", err)
+		return DownloadResponse{}, fmt.Errorf("%w: %v", errUpload, err)
+	}
+	return downloadResponse(result, info.Size(), publicURL, opts, urlType), nil
+}
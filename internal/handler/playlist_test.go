@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const playlistBody = `{"url":"https://www.youtube.com/playlist?list=PL1","playlist":true,"max_items":4}`
+
+func TestPlaylistUploadsConcurrently(t *testing.T) {
+	f := newFakeHandler(t, Config{PlaylistMaxItems: 10, PlaylistUploadConcurrency: 2})
+	f.store.delay = 20 * time.Millisecond
+
+	var resp DownloadResponse
+	rec := postDownload(t, f.h, playlistBody, &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if f.store.maxInFlight != 2 {
+		t.Errorf("max concurrent uploads = %d, want 2", f.store.maxInFlight)
+	}
+	if len(resp.Items) != 4 || resp.Filesize != 4*int64(len(fakeContent)) || resp.Duration != 4*fakeDuration {
+		t.Fatalf("response = %+v", resp)
+	}
+	// Items keep playlist order whatever order the uploads finish in
+	for i, item := range resp.Items {
+		if want := "Title entry" + string(rune('0'+i)); item.Title != want || item.DownloadURL == "" {
+			t.Errorf("item %d = %+v, want %q", i, item, want)
+		}
+	}
+}
+
+func TestPlaylistUploadFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		partial    bool
+		failName   string
+		wantStatus int
+		wantFailed int
+	}{
+		{"strict", false, "entry2", http.StatusInternalServerError, 0},
+		{"partial", true, "entry2", http.StatusOK, 1},
+		{"partial all failed", true, "entry", http.StatusInternalServerError, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHandler(t, Config{PlaylistMaxItems: 10, PlaylistUploadConcurrency: 3, PlaylistPartialResults: tt.partial})
+			f.store.err = errors.New("bucket gone")
+			f.store.failName = tt.failName
+
+			var resp DownloadResponse
+			rec := postDownload(t, f.h, playlistBody, &resp)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			failed := 0
+			for _, item := range resp.Items {
+				if item.Error != "" {
+					failed++
+					if item.DownloadURL != "" || item.Title != "Title entry2" {
+						t.Errorf("failed item = %+v", item)
+					}
+				}
+			}
+			if len(resp.Items) != 4 || failed != tt.wantFailed {
+				t.Errorf("items = %d, failed = %d, want 4 and %d", len(resp.Items), failed, tt.wantFailed)
+			}
+			if resp.Filesize != 3*int64(len(fakeContent)) {
+				t.Errorf("filesize = %d, want the uploaded entries' total", resp.Filesize)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/downloader"
+	"github.com/emanuelef/yt-dl-api-go/internal/redact"
+)
+
+const (
+	// maxPrewarmURLs caps the URLs accepted by one prewarm request.
+	maxPrewarmURLs = 50
+	// prewarmConcurrency bounds simultaneous resolves; the downloader's
+	// MaxConcurrentInfo limit applies on top.
+	prewarmConcurrency = 4
+	// PrewarmTimeout bounds a whole prewarm request.
+	PrewarmTimeout = 2 * time.Minute
+)
+
+// PrewarmRequest is the expected JSON body for POST /api/admin/prewarm.
+type PrewarmRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// PrewarmResponse reports the outcome of a prewarm request.
+type PrewarmResponse struct {
+	Warmed   int              `json:"warmed"`
+	Failed   int              `json:"failed"`
+	Failures []PrewarmFailure `json:"failures,omitempty"`
+}
+
+// PrewarmFailure describes a URL that could not be resolved.
+type PrewarmFailure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// Prewarm handles POST /api/admin/prewarm, resolving the info of a list of
+// URLs into the info cache so that later GET /api/info calls are instant.
+// Entries already cached are refreshed.
+func (h *Handler) Prewarm(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), PrewarmTimeout)
+	defer cancel()
+
+	var req PrewarmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorJSON(w, "Invalid JSON body", "INVALID_JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.URLs) == 0 || len(req.URLs) > maxPrewarmURLs {
+		h.errorJSON(w, fmt.Sprintf("urls must list 1 to %d URLs", maxPrewarmURLs), "INVALID_URL", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		resp  PrewarmResponse
+		slots = make(chan struct{}, prewarmConcurrency)
+	)
+	for _, rawURL := range req.URLs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			failure := h.prewarm(ctx, rawURL)
+			mu.Lock()
+			defer mu.Unlock()
+			if failure != nil {
+				resp.Failed++
+				resp.Failures = append(resp.Failures, *failure)
+				return
+			}
+			resp.Warmed++
+		}()
+	}
+	wg.Wait()
+
+	slog.Info("Info cache prewarmed", "warmed", resp.Warmed, "failed", resp.Failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// prewarm resolves one URL into the info cache, describing the failure if
+// it could not.
+func (h *Handler) prewarm(ctx context.Context, rawURL string) *PrewarmFailure {
+	videoURL := h.prep.Apply(rawURL)
+	if err := h.validateURL(ctx, videoURL); err != nil {
+		return &PrewarmFailure{URL: rawURL, Error: err.Error(), Code: urlErrorCode(err)}
+	}
+	info, err := h.dl.Resolve(ctx, videoURL, downloader.Options{})
+	if err != nil {
+		slog.Warn("Prewarm failed", "error", err, "url", redact.URL(videoURL))
+		message, code, _ := h.downloadErrorStatus(err)
+		return &PrewarmFailure{URL: rawURL, Error: message, Code: code}
+	}
+	h.cache.Set(videoKey(videoURL), info, h.cacheTTL(videoURL))
+	return nil
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// usageQuota tracks usage per client within a fixed window: bytes
+// downloaded per IP, or downloads per API key.
+type usageQuota struct {
+	mu     sync.Mutex
+	limit  int64
+	window time.Duration
+	usage  map[string]*quotaUsage
+}
+
+type quotaUsage struct {
+	used  int64
+	start time.Time
+}
+
+func newUsageQuota(limit int64, window time.Duration) *usageQuota {
+	return &usageQuota{limit: limit, window: window, usage: make(map[string]*quotaUsage)}
+}
+
+// exceeded reports whether client has used up its quota for the current window.
+func (q *usageQuota) exceeded(client string) bool {
+	if q.limit <= 0 {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u, ok := q.usage[client]
+	return ok && time.Since(u.start) < q.window && u.used >= q.limit
+}
+
+// add records n units of usage for client.
+func (q *usageQuota) add(client string, n int64) {
+	if q.limit <= 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for c, u := range q.usage {
+		if now.Sub(u.start) >= q.window {
+			delete(q.usage, c)
+		}
+	}
+
+	u, ok := q.usage[client]
+	if !ok {
+		u = &quotaUsage{start: now}
+		q.usage[client] = u
+	}
+	u.used += n
+}
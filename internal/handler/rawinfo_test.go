@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRawInfo(t *testing.T) {
+	f := newFakeHandler(t, Config{})
+	f.dl.raw = json.RawMessage(`{"id": "abc", "formats": [{"format_id": "18"}], "extra": {"nested": [1, 2.50, null]}}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/info/raw?url=https://youtu.be/abc", nil)
+	rec := httptest.NewRecorder()
+	f.h.RawInfo(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Body.String(); got != string(f.dl.raw) {
+		t.Errorf("body =\n %s\nwant\n %s", got, f.dl.raw)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyCacheTTL is how long a readiness result is reused, so frequent
+// probes do not start yt-dlp and call storage on every request.
+const readyCacheTTL = 5 * time.Second
+
+// readiness caches the result of the readiness checks. Concurrent callers
+// wait for the one check in progress instead of starting their own.
+type readiness struct {
+	mu      sync.Mutex
+	checked time.Time
+	status  int
+	results map[string]string
+}
+
+// get returns the cached result, running check when it is older than
+// readyCacheTTL.
+func (r *readiness) get(check func() (int, map[string]string)) (int, map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.results == nil || time.Since(r.checked) >= readyCacheTTL {
+		r.status, r.results = check()
+		r.checked = time.Now()
+	}
+	return r.status, r.results
+}
+
+// checkReady runs the readiness checks and returns the HTTP status and the
+// result of each check. A client going away does not cancel the checks, as
+// their result is shared with other callers.
+func (h *Handler) checkReady(ctx context.Context) (int, map[string]string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"ytdlp":   h.dl.Check,
+		"storage": h.store.Check,
+	}
+
+	status := http.StatusOK
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		if err := check(ctx); err != nil {
+			slog.Warn("Readiness check failed", "check", name, "error", err)
+			results[name] = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		results[name] = "ok"
+	}
+	return status, results
+}
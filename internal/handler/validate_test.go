@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeResolver answers lookups from a fixed table.
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func TestValidateURLScheme(t *testing.T) {
+	tests := []struct {
+		url        string
+		wantScheme bool // rejected for its scheme
+	}{
+		{"https://www.youtube.com/watch?v=abc", false},
+		{"http://youtu.be/abc", false},
+		{"HTTPS://youtu.be/abc", false},
+		{"file:///etc/passwd", true},
+		{"FILE:///etc/passwd", true},
+		{"data:text/html,<script>", true},
+		{"ftp://youtube.com/video", true},
+		{"gopher://youtube.com/", true},
+		{"javascript:alert(1)", true},
+		{"ytsearch:cats", true},
+		{"//youtube.com/watch?v=abc", true},
+		{"youtube.com/watch?v=abc", true},
+		{"httpx://youtube.com/", true},
+	}
+	for _, mode := range []string{ModeAllowlist, ModeSSRFOnly} {
+		f := newFakeHandler(t, Config{DownloadMode: mode})
+		f.h.resolver = fakeResolver{"youtu.be": "142.250.0.1", "www.youtube.com": "142.250.0.1"}
+		for _, tt := range tests {
+			err := f.h.validateURL(context.Background(), tt.url)
+			if got := errors.Is(err, errInvalidScheme); got != tt.wantScheme {
+				t.Errorf("%s: validateURL(%q) = %v, want scheme rejection %v", mode, tt.url, err, tt.wantScheme)
+			}
+			if tt.wantScheme && urlErrorCode(err) != "INVALID_SCHEME" {
+				t.Errorf("%s: code for %q = %s", mode, tt.url, urlErrorCode(err))
+			}
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		mode, url string
+		ok        bool
+	}{
+		{ModeAllowlist, "https://www.youtube.com/watch?v=abc", true},
+		{ModeAllowlist, "https://vm.tiktok.com/abc", true},
+		{ModeAllowlist, "https://youtube.com.evil.com/watch", false},
+		{ModeAllowlist, "https://example.com/video", false},
+		{ModeAllowlist, "https://public.test/video", false},
+		{ModeAllowlist, "ftp://youtube.com/video", false},
+		{ModeAllowlist, "", false},
+		{ModeAllowlist, "https://youtu.be/abc;rm -rf /", false},
+		{ModeAllowlist, "https://youtu.be/abc$(id)", false},
+		{ModeSSRFOnly, "https://public.test/video", true},
+		{ModeSSRFOnly, "https://www.youtube.com/watch?v=abc", true},
+		{ModeSSRFOnly, "https://internal.test/video", false},
+		{ModeSSRFOnly, "https://169.254.169.254/latest/meta-data", false},
+		{ModeSSRFOnly, "http://[::1]:8080/", false},
+		{ModeSSRFOnly, "https://missing.test/video", false},
+		{ModeSSRFOnly, "ftp://public.test/video", false},
+		{ModeSSRFOnly, "https://public.test/v|id", false},
+	}
+	for _, tt := range tests {
+		f := newFakeHandler(t, Config{DownloadMode: tt.mode})
+		f.h.resolver = fakeResolver{"public.test": "93.184.216.34", "internal.test": "10.0.0.5", "www.youtube.com": "142.250.0.1"}
+		err := f.h.validateURL(context.Background(), tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: validateURL(%q) = %v, want ok %v", tt.mode, tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateURLCharacters(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://youtu.be/abc?t=10", true},
+		{"https://www.youtube.com/watch?v=a-b_c%2Fd", true},
+		{"https://youtu.be/abc --exec id", false},
+		{"https://youtu.be/abc\t--exec", false},
+		{"https://youtu.be/abc\n--exec", false},
+		{"https://youtu.be/abc\x00", false},
+		{"https://youtu.be/abc\x7f", false},
+		{"https://youtu.be/abc --exec", false}, // non-breaking space
+		{"https://youtu.be/abc ", false},       // line separator
+		{"https://youtu.be/abc%20--exec", false},
+		{"https://youtu.be/abc%0a--exec", false},
+		{"https://youtu.be/abc%3Bid", false},
+		{"https://youtu.be/abc%7Cid", false},
+		{"https://youtu.be/abc%24(id)", false},
+		{"https://youtu.be/abc%60id%60", false},
+		{"https://youtu.be/abc%5c", false},
+	}
+	f := newFakeHandler(t, Config{})
+	for _, tt := range tests {
+		err := f.h.validateURL(context.Background(), tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateURL(%q) = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
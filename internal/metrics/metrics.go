@@ -0,0 +1,65 @@
+// Package metrics publishes application metrics through expvar.
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Handler serves every published metric as JSON.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
+
+// Histogram counts observations into cumulative "less or equal" buckets.
+// It implements expvar.Var.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewHistogram creates a histogram with the given ascending bucket bounds
+// and publishes it under name.
+func NewHistogram(name string, bounds ...float64) *Histogram {
+	h := &Histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+	expvar.Publish(name, h)
+	return h
+}
+
+// Observe records a value.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// String returns the histogram as JSON.
+func (h *Histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make(map[string]uint64, len(h.bounds)+1)
+	for i, b := range h.bounds {
+		buckets[strconv.FormatFloat(b, 'g', -1, 64)] = h.buckets[i]
+	}
+	buckets["+Inf"] = h.count
+
+	out, _ := json.Marshal(struct {
+		Buckets map[string]uint64 `json:"buckets"`
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+	}{buckets, h.count, h.sum})
+	return string(out)
+}
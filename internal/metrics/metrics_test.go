@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_histogram_seconds", 1, 5, 10)
+	for _, v := range []float64{0.5, 1, 3, 7, 30} {
+		h.Observe(v)
+	}
+
+	// Read the value back the way the metrics endpoint publishes it
+	var got struct {
+		Buckets map[string]uint64 `json:"buckets"`
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("test_histogram_seconds").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint64{"1": 2, "5": 3, "10": 4, "+Inf": 5}
+	for bound, n := range want {
+		if got.Buckets[bound] != n {
+			t.Errorf("bucket %s = %d, want %d", bound, got.Buckets[bound], n)
+		}
+	}
+	if got.Count != 5 || got.Sum != 41.5 {
+		t.Errorf("count %d, sum %g; want 5, 41.5", got.Count, got.Sum)
+	}
+}
@@ -2,6 +2,10 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"log/slog"
 	"net/http"
@@ -22,7 +26,7 @@ func Logger(next http.Handler) http.Handler {
 			"path", r.URL.Path,
 			"status", wrapped.statusCode,
 			"duration", time.Since(start).String(),
-			"ip", getIP(r),
+			"ip", ClientIP(r),
 		)
 	})
 }
@@ -37,6 +41,26 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// WriteTimeout overrides the server's write timeout for a route.
+// A zero timeout disables the deadline, for streaming responses.
+func WriteTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+			slog.Warn("Could not set write deadline", "error", err, "path", r.URL.Path)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // CORS handles Cross-Origin Resource Sharing.
 func CORS(next http.Handler, allowedOrigins []string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -53,7 +77,7 @@ func CORS(next http.Handler, allowedOrigins []string) http.Handler {
 		if allowed {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Turnstile-Token")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Turnstile-Token, X-API-Key, Authorization")
 			w.Header().Set("Access-Control-Max-Age", "86400")
 		}
 
@@ -66,57 +90,140 @@ func CORS(next http.Handler, allowedOrigins []string) http.Handler {
 	})
 }
 
+// MaxConcurrent caps the number of requests served at once, regardless of
+// client, and rejects the excess with 503. Health and readiness checks are
+// never rejected.
+func MaxConcurrent(next http.Handler, limit int) http.Handler {
+	slots := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isProbe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "5")
+			errorJSON(w, "Server is at capacity, try again later", "SERVER_BUSY", http.StatusServiceUnavailable)
+		}
+	})
+}
+
 // RateLimit limits requests per IP.
 func RateLimit(next http.Handler, requestsPerMinute int) http.Handler {
-	type client struct {
-		count    int
-		lastSeen time.Time
+	return NewRateLimiter(requestsPerMinute, nil, nil).Wrap(next)
+}
+
+// Counter counts a client's requests within the last minute.
+type Counter interface {
+	Incr(ctx context.Context, client string) (int, error)
+}
+
+// RateLimiter counts requests per client per minute. Clients are API keys
+// for requests authenticated by APIKey, IPs otherwise. Every handler
+// wrapped by the same RateLimiter shares its counts.
+type RateLimiter struct {
+	requestsPerMinute int
+	keyLimits         map[string]int
+	counter           Counter
+}
+
+// NewRateLimiter creates a RateLimiter. keyLimits overrides
+// requestsPerMinute for API keys, by APIKeyID. counter stores the counts,
+// e.g. shared between replicas; nil keeps them in memory.
+func NewRateLimiter(requestsPerMinute int, keyLimits map[string]int, counter Counter) *RateLimiter {
+	if counter == nil {
+		counter = NewMemoryCounter()
+	}
+	return &RateLimiter{
+		requestsPerMinute: requestsPerMinute,
+		keyLimits:         keyLimits,
+		counter:           counter,
 	}
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+}
+
+// MemoryCounter is a Counter local to this process.
+type MemoryCounter struct {
+	mu      sync.Mutex
+	clients map[string]*rateClient
+}
+
+type rateClient struct {
+	count    int
+	lastSeen time.Time
+}
+
+// NewMemoryCounter creates a MemoryCounter and starts removing idle
+// clients every minute.
+func NewMemoryCounter() *MemoryCounter {
+	m := &MemoryCounter{clients: make(map[string]*rateClient)}
 
 	// Cleanup old entries every minute
 	go func() {
 		for range time.Tick(time.Minute) {
-			mu.Lock()
+			m.mu.Lock()
 			cutoff := time.Now().Add(-time.Minute)
-			for ip, c := range clients {
+			for client, c := range m.clients {
 				if c.lastSeen.Before(cutoff) {
-					delete(clients, ip)
+					delete(m.clients, client)
 				}
 			}
-			mu.Unlock()
+			m.mu.Unlock()
 		}
 	}()
+	return m
+}
+
+// Incr counts a request from client.
+func (m *MemoryCounter) Incr(ctx context.Context, client string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, exists := m.clients[client]
+	if !exists {
+		c = &rateClient{}
+		m.clients[client] = c
+	}
+
+	// Reset if more than a minute has passed
+	if time.Since(c.lastSeen) > time.Minute {
+		c.count = 0
+	}
+
+	c.count++
+	c.lastSeen = time.Now()
+	return c.count, nil
+}
 
+// Wrap limits next with the limiter's shared counts.
+func (l *RateLimiter) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip rate limiting for health checks
-		if r.URL.Path == "/api/health" {
+		if isProbe(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		ip := getIP(r)
-		mu.Lock()
-		c, exists := clients[ip]
-		if !exists {
-			c = &client{}
-			clients[ip] = c
+		client, limit := "ip:"+ClientIP(r), l.requestsPerMinute
+		if id := APIKeyID(r.Context()); id != "" {
+			client = "key:" + id
+			if n, ok := l.keyLimits[id]; ok {
+				limit = n
+			}
 		}
 
-		// Reset if more than a minute has passed
-		if time.Since(c.lastSeen) > time.Minute {
-			c.count = 0
+		count, err := l.counter.Incr(r.Context(), client)
+		if err != nil {
+			// Fail open: an unreachable counter must not take the API down
+			slog.Warn("Rate limit counter unavailable, allowing request", "error", err)
+			next.ServeHTTP(w, r)
+			return
 		}
 
-		c.count++
-		c.lastSeen = time.Now()
-		count := c.count
-		mu.Unlock()
-
-		if count > requestsPerMinute {
+		if count > limit {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusTooManyRequests)
 			json.NewEncoder(w).Encode(map[string]string{
@@ -130,11 +237,94 @@ func RateLimit(next http.Handler, requestsPerMinute int) http.Handler {
 	})
 }
 
-// Turnstile verifies Cloudflare Turnstile tokens.
-func Turnstile(next http.Handler, secretKey string) http.Handler {
+// AdminKey restricts access to requests carrying the admin API key, either
+// as "Authorization: Bearer <key>" or "X-API-Key: <key>".
+func AdminKey(next http.Handler, key string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip for non-POST requests and health checks
-		if r.Method != http.MethodPost || r.URL.Path == "/api/health" {
+		provided := providedKey(r)
+		if key == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(key)) != 1 {
+			errorJSON(w, "Invalid API key", "UNAUTHORIZED", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyContextKey is the context key for the ID of a request's API key.
+type apiKeyContextKey struct{}
+
+// APIKey authenticates requests carrying one of keys, sent like the admin
+// key. Requests with a valid key skip Turnstile, and the key's ID is
+// available to handlers through APIKeyID. A key that matches none of keys
+// is rejected with 401. When required, requests without a key are rejected
+// too, except health checks and signed file links.
+func APIKey(next http.Handler, keys []string, required bool) http.Handler {
+	hashes := make([][sha256.Size]byte, len(keys))
+	for i, k := range keys {
+		hashes[i] = sha256.Sum256([]byte(k))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := providedKey(r)
+		if provided == "" {
+			if required && !isProbe(r) && !strings.HasPrefix(r.URL.Path, "/api/files/") {
+				errorJSON(w, "API key required", "UNAUTHORIZED", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Hashing gives equal lengths, and every key is compared so the
+		// timing does not reveal which one matched
+		sum := sha256.Sum256([]byte(provided))
+		match := 0
+		for _, h := range hashes {
+			match |= subtle.ConstantTimeCompare(sum[:], h[:])
+		}
+		if match != 1 {
+			errorJSON(w, "Invalid API key", "UNAUTHORIZED", http.StatusUnauthorized)
+			return
+		}
+
+		id := keyID(sum)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, id)))
+	})
+}
+
+// keyID derives a key's ID: the first 8 hex digits of its SHA-256.
+func keyID(sum [sha256.Size]byte) string {
+	return hex.EncodeToString(sum[:4])
+}
+
+// APIKeyID returns a non-secret identifier of the API key that
+// authenticated the request, or "" if there was none.
+func APIKeyID(ctx context.Context) string {
+	id, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return id
+}
+
+// providedKey returns the key from "Authorization: Bearer" or "X-API-Key".
+func providedKey(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return bearer
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// Turnstile verifies Cloudflare Turnstile tokens. When expectedAction is set,
+// tokens issued for any other widget action are rejected.
+func Turnstile(next http.Handler, secretKey, expectedAction string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Skip for non-POST requests, health checks and admin routes (which
+		// are authenticated by AdminKey instead)
+		if r.Method != http.MethodPost || r.URL.Path == "/api/health" || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// API key clients are authenticated already
+		if APIKeyID(r.Context()) != "" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -145,17 +335,29 @@ func Turnstile(next http.Handler, secretKey string) http.Handler {
 			return
 		}
 
-		if !verifyTurnstile(token, secretKey, getIP(r)) {
+		ok, action := verifyTurnstile(token, secretKey, ClientIP(r))
+		if !ok {
 			errorJSON(w, "Invalid Turnstile token", "TURNSTILE_INVALID", http.StatusForbidden)
 			return
 		}
+		if expectedAction != "" && action != expectedAction {
+			slog.Warn("Turnstile action mismatch", "action", action, "expected", expectedAction)
+			errorJSON(w, "Turnstile token was issued for another action", "TURNSTILE_ACTION_MISMATCH", http.StatusForbidden)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-func verifyTurnstile(token, secretKey, ip string) bool {
-	resp, err := http.PostForm("https://challenges.cloudflare.com/turnstile/v0/siteverify",
+// turnstileVerifyURL is Cloudflare's token verification endpoint; tests
+// point it at a stub.
+var turnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+// verifyTurnstile checks a token with Cloudflare and returns whether it is
+// valid and the action the widget was rendered with.
+func verifyTurnstile(token, secretKey, ip string) (bool, string) {
+	resp, err := http.PostForm(turnstileVerifyURL,
 		url.Values{
 			"secret":   {secretKey},
 			"response": {token},
@@ -163,20 +365,22 @@ func verifyTurnstile(token, secretKey, ip string) bool {
 		})
 	if err != nil {
 		slog.Error("Turnstile verification failed", "error", err)
-		return false
+		return false, ""
 	}
 	defer resp.Body.Close()
 
 	var result struct {
-		Success bool `json:"success"`
+		Success bool   `json:"success"`
+		Action  string `json:"action"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false
+		return false, ""
 	}
-	return result.Success
+	return result.Success, result.Action
 }
 
-func getIP(r *http.Request) string {
+// ClientIP returns the client's IP, honouring common proxy headers.
+func ClientIP(r *http.Request) string {
 	// Check common proxy headers
 	if ip := r.Header.Get("CF-Connecting-IP"); ip != "" {
 		return ip
@@ -190,6 +394,11 @@ func getIP(r *http.Request) string {
 	return strings.Split(r.RemoteAddr, ":")[0]
 }
 
+// isProbe reports whether r is a liveness or readiness check.
+func isProbe(r *http.Request) bool {
+	return r.URL.Path == "/api/health" || r.URL.Path == "/api/ready"
+}
+
 func errorJSON(w http.ResponseWriter, message, code string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIKey(t *testing.T) {
+	keys := []string{"first-key", "second-key"}
+	id := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:4])
+	}
+	firstID, secondID := id("first-key"), id("second-key")
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		value    string
+		required bool
+		want     int
+		wantID   string
+	}{
+		{"bearer", "/api/download", "Authorization", "Bearer first-key", false, http.StatusOK, firstID},
+		{"x-api-key", "/api/download", "X-API-Key", "second-key", true, http.StatusOK, secondID},
+		{"wrong key", "/api/download", "X-API-Key", "third-key", false, http.StatusUnauthorized, ""},
+		{"key prefix", "/api/download", "X-API-Key", "first", false, http.StatusUnauthorized, ""},
+		{"key with suffix", "/api/download", "X-API-Key", "first-key ", false, http.StatusUnauthorized, ""},
+		{"other case", "/api/download", "X-API-Key", "FIRST-KEY", false, http.StatusUnauthorized, ""},
+		{"basic auth", "/api/download", "Authorization", "Basic first-key", false, http.StatusOK, ""},
+		{"optional and missing", "/api/download", "", "", false, http.StatusOK, ""},
+		{"required and missing", "/api/download", "", "", true, http.StatusUnauthorized, ""},
+		{"required, health check", "/api/health", "", "", true, http.StatusOK, ""},
+		{"required, file link", "/api/files/1_abc.mp4", "", "", true, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotID string
+			h := APIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = APIKeyID(r.Context())
+			}), keys, tt.required)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want || gotID != tt.wantID {
+				t.Errorf("status %d, key ID %q; want %d, %q", rec.Code, gotID, tt.want, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAPIKeyNoKeysConfigured(t *testing.T) {
+	h := APIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil, false)
+	req := httptest.NewRequest(http.MethodGet, "/api/download", nil)
+	req.Header.Set("X-API-Key", "anything")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
+
+func TestAdminKey(t *testing.T) {
+	tests := []struct {
+		name, configured, header, provided string
+		want                               int
+	}{
+		{"bearer", "admin-secret", "Authorization", "Bearer admin-secret", http.StatusOK},
+		{"x-api-key", "admin-secret", "X-API-Key", "admin-secret", http.StatusOK},
+		{"mismatch", "admin-secret", "Authorization", "Bearer admin-secreT", http.StatusUnauthorized},
+		{"prefix", "admin-secret", "X-API-Key", "admin", http.StatusUnauthorized},
+		{"missing", "admin-secret", "", "", http.StatusUnauthorized},
+		{"disabled", "", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		h := AdminKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), tt.configured)
+		req := httptest.NewRequest(http.MethodGet, "/api/info/raw", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.provided)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+// slowStream writes chunks of a file over about 600ms.
+func slowStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "video/mp4")
+	chunk := make([]byte, 32*1024)
+	for range 12 {
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+		http.NewResponseController(w).Flush()
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestWriteTimeoutStreaming(t *testing.T) {
+	const size = 12 * 32 * 1024
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		complete bool
+	}{
+		{"server timeout", http.HandlerFunc(slowStream), false},
+		{"route without deadline", WriteTimeout(http.HandlerFunc(slowStream), 0), true},
+		{"route with longer deadline", WriteTimeout(http.HandlerFunc(slowStream), 5*time.Second), true},
+		{"behind the logger", Logger(WriteTimeout(http.HandlerFunc(slowStream), 0)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The stream takes three times the server's write timeout
+			srv := httptest.NewUnstartedServer(tt.handler)
+			srv.Config.WriteTimeout = 200 * time.Millisecond
+			srv.Start()
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			n, err := io.Copy(io.Discard, resp.Body)
+			if complete := err == nil && n == size; complete != tt.complete {
+				t.Errorf("read %d of %d bytes (%v), want complete %v", n, size, err, tt.complete)
+			}
+		})
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(MaxConcurrent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	}), 2))
+	defer srv.Close()
+
+	// Fill both slots with requests that stay open
+	done := make(chan int, 2)
+	for range 2 {
+		go func() {
+			resp, err := http.Get(srv.URL + "/slow")
+			if err != nil {
+				done <- 0
+				return
+			}
+			resp.Body.Close()
+			done <- resp.StatusCode
+		}()
+		<-started
+	}
+
+	resp, err := http.Get(srv.URL + "/api/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("at capacity: status %d, Retry-After %q; want 503 with Retry-After", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Probes bypass the cap
+	for _, path := range []string{"/api/health", "/api/ready"} {
+		resp, err = http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s at capacity: status %d, want 200", path, resp.StatusCode)
+		}
+	}
+
+	close(release)
+	for range 2 {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("held request: status %d, want 200", code)
+		}
+	}
+	resp, err = http.Get(srv.URL + "/api/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("after release: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestTurnstileAction(t *testing.T) {
+	// The stub accepts every token and reports it was issued for "download"
+	siteverify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "secret" || r.FormValue("response") != "token" {
+			w.Write([]byte(`{"success": false}`))
+			return
+		}
+		w.Write([]byte(`{"success": true, "action": "download"}`))
+	}))
+	defer siteverify.Close()
+	defer func(u string) { turnstileVerifyURL = u }(turnstileVerifyURL)
+	turnstileVerifyURL = siteverify.URL
+
+	tests := []struct {
+		name     string
+		expected string
+		token    string
+		want     int
+		wantCode string
+	}{
+		{"matching action", "download", "token", http.StatusOK, ""},
+		{"action not checked", "", "token", http.StatusOK, ""},
+		{"action mismatch", "signup", "token", http.StatusForbidden, "TURNSTILE_ACTION_MISMATCH"},
+		{"invalid token", "download", "forged", http.StatusForbidden, "TURNSTILE_INVALID"},
+		{"missing token", "download", "", http.StatusBadRequest, "TURNSTILE_MISSING"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Turnstile(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "secret", tt.expected)
+			req := httptest.NewRequest(http.MethodPost, "/api/download", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Turnstile-Token", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			var resp struct {
+				Code string `json:"code"`
+			}
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if rec.Code != tt.want || resp.Code != tt.wantCode {
+				t.Errorf("got %d %q, want %d %q", rec.Code, resp.Code, tt.want, tt.wantCode)
+			}
+		})
+	}
+}
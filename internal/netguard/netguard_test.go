@@ -0,0 +1,106 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestIsForbiddenIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:4700:4700::1111", false},
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"::1", true},
+		{"10.0.0.1", true},
+		{"172.16.5.4", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"fe80::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+		{"255.255.255.255", true},
+		{"240.0.0.1", true},
+		{"192.0.2.10", true},
+		{"198.51.100.7", true},
+		{"203.0.113.9", true},
+		{"198.18.0.1", true},
+		{"2001:db8::1", true},
+		{"::ffff:127.0.0.1", true}, // IPv4-mapped loopback
+		{"::ffff:10.0.0.1", true},  // IPv4-mapped private
+		{"64:ff9b::a00:1", true},   // NAT64 of 10.0.0.1
+		{"::ffff:8.8.8.8", false},  // IPv4-mapped public
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("bad test IP %q", tt.ip)
+		}
+		if got := IsForbiddenIP(ip); got != tt.want {
+			t.Errorf("IsForbiddenIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// fakeResolver answers lookups from a fixed table.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestCheckHost(t *testing.T) {
+	resolver := fakeResolver{
+		"public.test":   {"93.184.216.34", "2606:2800:220:1::1"},
+		"private.test":  {"10.1.2.3"},
+		"mixed.test":    {"93.184.216.34", "127.0.0.1"},
+		"metadata.test": {"169.254.169.254"},
+		"empty.test":    {},
+	}
+	tests := []struct {
+		host      string
+		wantErr   bool
+		forbidden bool
+	}{
+		{"public.test", false, false},
+		{"8.8.8.8", false, false},
+		{"private.test", true, true},
+		{"mixed.test", true, true}, // one bad address is enough
+		{"metadata.test", true, true},
+		{"127.0.0.1", true, true},
+		{"::1", true, true},
+		{"missing.test", true, false},
+		{"empty.test", true, false},
+	}
+	for _, tt := range tests {
+		err := CheckHost(context.Background(), resolver, tt.host)
+		if (err != nil) != tt.wantErr || errors.Is(err, ErrForbiddenAddress) != tt.forbidden {
+			t.Errorf("CheckHost(%q) = %v, want error %v, forbidden %v", tt.host, err, tt.wantErr, tt.forbidden)
+		}
+	}
+}
+
+func TestHTTPClientRefusesForbiddenAddress(t *testing.T) {
+	_, err := NewHTTPClient(0).Get("http://127.0.0.1:1/")
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Get loopback error = %v, want ErrForbiddenAddress", err)
+	}
+}
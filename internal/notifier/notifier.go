@@ -0,0 +1,186 @@
+// Package notifier sends notifications when downloads finish.
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a recipient has received too many notifications.
+var ErrRateLimited = errors.New("notification rate limit exceeded")
+
+// Notification describes a finished download.
+type Notification struct {
+	Email       string
+	CallbackURL string
+	VideoURL    string
+	DownloadURL string
+	Error       string // empty on success
+}
+
+// Noop discards all notifications. It is used when no notifier is configured.
+type Noop struct{}
+
+// Notify does nothing.
+func (Noop) Notify(ctx context.Context, n Notification) error {
+	return nil
+}
+
+// SMTP emails notifications through an SMTP server.
+type SMTP struct {
+	host    string
+	addr    string
+	from    string
+	auth    smtp.Auth
+	perHour int
+
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+// NewSMTP creates an SMTP notifier. perHour caps notifications per recipient.
+func NewSMTP(host string, port int, username, password, from string, perHour int) *SMTP {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTP{
+		host:    host,
+		addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		from:    from,
+		auth:    auth,
+		perHour: perHour,
+		sent:    make(map[string][]time.Time),
+	}
+}
+
+// ValidateEmail checks that s is a bare email address safe to use as a recipient.
+func ValidateEmail(s string) error {
+	if len(s) > 254 || strings.ContainsAny(s, "\r\n") {
+		return errors.New("invalid email address")
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return errors.New("invalid email address")
+	}
+	return nil
+}
+
+// Notify emails the notification to n.Email. Notifications without an
+// email address are ignored.
+func (s *SMTP) Notify(ctx context.Context, n Notification) error {
+	if n.Email == "" {
+		return nil
+	}
+	if err := ValidateEmail(n.Email); err != nil {
+		return err
+	}
+	if !s.allow(strings.ToLower(n.Email)) {
+		return ErrRateLimited
+	}
+	return s.send(ctx, n.Email, s.message(n))
+}
+
+// allow records a notification for the recipient if it is under the hourly cap.
+func (s *SMTP) allow(to string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Hour)
+	recent := s.sent[to][:0]
+	for _, t := range s.sent[to] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= s.perHour {
+		s.sent[to] = recent
+		return false
+	}
+	s.sent[to] = append(recent, time.Now())
+
+	// Drop recipients with no recent notifications
+	for addr, times := range s.sent {
+		if len(times) == 0 || times[len(times)-1].Before(cutoff) {
+			delete(s.sent, addr)
+		}
+	}
+	return true
+}
+
+// message builds the email for a notification.
+func (s *SMTP) message(n Notification) []byte {
+	subject := "Your download is ready"
+	body := fmt.Sprintf("Your video is ready to download:\r\n\r\n%s\r\n\r\nSource: %s\r\n", n.DownloadURL, n.VideoURL)
+	if n.Error != "" {
+		subject = "Your download failed"
+		body = fmt.Sprintf("We could not download your video.\r\n\r\nSource: %s\r\nReason: %s\r\n", n.VideoURL, n.Error)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", n.Email)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
+
+// send delivers msg over SMTP, upgrading to TLS when the server supports it.
+func (s *SMTP) send(ctx context.Context, to string, msg []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return fmt.Errorf("SMTP auth failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,184 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStub is a minimal SMTP server that records the messages it receives.
+type smtpStub struct {
+	ln       net.Listener
+	messages chan smtpMessage
+}
+
+type smtpMessage struct {
+	from, to, data string
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpStub{ln: ln, messages: make(chan smtpMessage, 10)}
+	go s.serve()
+	return s
+}
+
+// port returns the port the stub listens on.
+func (s *smtpStub) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+func (s *smtpStub) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 stub ESMTP")
+	var msg smtpMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		switch verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0]); verb {
+		case "EHLO", "HELO":
+			reply("250 stub")
+		case "MAIL":
+			msg.from = strings.TrimPrefix(cmd, "MAIL FROM:")
+			reply("250 OK")
+		case "RCPT":
+			msg.to = strings.TrimPrefix(cmd, "RCPT TO:")
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			msg.data = data.String()
+			s.messages <- msg
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// next returns the next message the stub received.
+func (s *smtpStub) next(t *testing.T) smtpMessage {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return smtpMessage{}
+	}
+}
+
+func TestSMTPNotify(t *testing.T) {
+	stub := newSMTPStub(t)
+	n := NewSMTP("127.0.0.1", stub.port(), "", "", "bot@example.com", 5)
+
+	err := n.Notify(context.Background(), Notification{
+		Email:       "user@example.com",
+		VideoURL:    "https://youtu.be/abc",
+		DownloadURL: "https://cdn.example.com/1_abc.mp4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := stub.next(t)
+	if msg.from != "<bot@example.com>" || msg.to != "<user@example.com>" {
+		t.Errorf("envelope = %s -> %s", msg.from, msg.to)
+	}
+	for _, want := range []string{"To: user@example.com\r\n", "Subject: Your download is ready\r\n", "https://cdn.example.com/1_abc.mp4"} {
+		if !strings.Contains(msg.data, want) {
+			t.Errorf("message is missing %q:\n%s", want, msg.data)
+		}
+	}
+
+	err = n.Notify(context.Background(), Notification{
+		Email:    "user@example.com",
+		VideoURL: "https://youtu.be/abc",
+		Error:    "Video is unavailable or private",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = stub.next(t)
+	if !strings.Contains(msg.data, "Subject: Your download failed\r\n") || !strings.Contains(msg.data, "Reason: Video is unavailable or private") {
+		t.Errorf("failure message:\n%s", msg.data)
+	}
+}
+
+func TestSMTPNotifyRateLimited(t *testing.T) {
+	stub := newSMTPStub(t)
+	n := NewSMTP("127.0.0.1", stub.port(), "", "", "bot@example.com", 1)
+	note := Notification{Email: "user@example.com", DownloadURL: "https://cdn.example.com/v.mp4"}
+
+	if err := n.Notify(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	stub.next(t)
+
+	// The cap is per recipient, whatever the case of the address
+	note.Email = "USER@example.com"
+	if err := n.Notify(context.Background(), note); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second Notify = %v, want ErrRateLimited", err)
+	}
+	note.Email = "other@example.com"
+	if err := n.Notify(context.Background(), note); err != nil {
+		t.Errorf("Notify for another recipient = %v", err)
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		ok    bool
+	}{
+		{"user@example.com", true},
+		{"first.last+tag@sub.example.org", true},
+		{"", false},
+		{"not-an-email", false},
+		{"User <user@example.com>", false},
+		{"user@example.com\r\nBcc: victim@example.com", false},
+		{strings.Repeat("a", 250) + "@x.io", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateEmail(tt.email); (err == nil) != tt.ok {
+			t.Errorf("ValidateEmail(%q) = %v, want ok %v", tt.email, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookAttempts is how many times a callback is tried before giving up.
+const webhookAttempts = 3
+
+// Webhook POSTs notifications as JSON to the request's callback URL.
+type Webhook struct {
+	client *http.Client
+}
+
+// NewWebhook creates a webhook notifier. The client should refuse private
+// addresses, e.g. one from netguard.NewHTTPClient.
+func NewWebhook(client *http.Client) *Webhook {
+	return &Webhook{client: client}
+}
+
+// webhookPayload is the JSON body sent to callback URLs.
+type webhookPayload struct {
+	Status      string `json:"status"` // "completed" or "failed"
+	VideoURL    string `json:"video_url"`
+	DownloadURL string `json:"download_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Notify posts the notification to n.CallbackURL, retrying with backoff.
+// Notifications without a callback URL are ignored.
+func (wh *Webhook) Notify(ctx context.Context, n Notification) error {
+	if n.CallbackURL == "" {
+		return nil
+	}
+
+	payload := webhookPayload{Status: "completed", VideoURL: n.VideoURL, DownloadURL: n.DownloadURL}
+	if n.Error != "" {
+		payload = webhookPayload{Status: "failed", VideoURL: n.VideoURL, Error: n.Error}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = wh.post(ctx, n.CallbackURL, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt.
+func (wh *Webhook) post(ctx context.Context, callbackURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("callback request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sender is implemented by every notifier in this package.
+type Sender interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Multi sends each notification through all of its notifiers.
+type Multi []Sender
+
+// Notify calls every notifier and joins their errors.
+func (m Multi) Notify(ctx context.Context, n Notification) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Notify(ctx, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package ratecount
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindow counts a request in the current fixed window and returns
+// the sliding window estimate: the current window's count plus the previous
+// window's, weighted by how much of it the sliding window still covers.
+// KEYS are the current and previous windows' counters; ARGV[1] is the
+// window in milliseconds and ARGV[2] the weight of the previous window.
+var slidingWindow = redis.NewScript(`local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1] * 2) end
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+return n + math.floor(prev * tonumber(ARGV[2]))`)
+
+// Counter counts requests per client over a sliding window shared by every
+// process using the same Redis. It keeps two counters per client, so a
+// burst at the end of one window still counts against the start of the
+// next, unlike fixed windows.
+type Counter struct {
+	client redis.Scripter
+	prefix string
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewCounter creates a Counter whose keys start with prefix.
+func NewCounter(client redis.Scripter, prefix string, window time.Duration) *Counter {
+	return &Counter{client: client, prefix: prefix, window: window, now: time.Now}
+}
+
+// Incr counts a request from client and returns its count in the window
+// ending now.
+func (c *Counter) Incr(ctx context.Context, client string) (int, error) {
+	now := c.now().UnixMilli()
+	window := c.window.Milliseconds()
+	current := now / window
+	// Share of the previous window still inside the sliding window
+	weight := 1 - float64(now%window)/float64(window)
+
+	key := c.prefix + client + ":"
+	n, err := slidingWindow.Run(ctx, c.client,
+		[]string{key + strconv.FormatInt(current, 10), key + strconv.FormatInt(current-1, 10)},
+		window, strconv.FormatFloat(weight, 'f', 4, 64),
+	).Int()
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
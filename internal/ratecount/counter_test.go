@@ -0,0 +1,107 @@
+package ratecount
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestCounter returns a Counter on an in-memory Redis with a clock set
+// to the start of a window, advanced by the returned function.
+func newTestCounter(t *testing.T) (*Counter, *miniredis.Miniredis, func(time.Duration)) {
+	t.Helper()
+	srv := miniredis.RunT(t)
+	client, err := New("redis://"+srv.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	c := NewCounter(client, "test:", time.Minute)
+	now := time.Unix(1_700_000_040, 0) // a window boundary
+	c.now = func() time.Time { return now }
+	return c, srv, func(d time.Duration) { now = now.Add(d) }
+}
+
+// incr counts n requests from client and returns the last count.
+func incr(t *testing.T, c *Counter, client string, n int) int {
+	t.Helper()
+	var count int
+	for range n {
+		var err error
+		if count, err = c.Incr(context.Background(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return count
+}
+
+func TestCounterSlidingWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance time.Duration // after 10 requests
+		want    int           // count of the next request
+	}{
+		{"same window", 30 * time.Second, 11},
+		{"start of next window", time.Minute, 11},
+		{"quarter into next window", 75 * time.Second, 8}, // 10*0.75 + 1
+		{"half into next window", 90 * time.Second, 6},    // 10*0.5 + 1
+		{"end of next window", 119 * time.Second, 1},      // floor(10/60) + 1
+		{"two windows later", 2 * time.Minute, 1},         // previous window is empty
+		{"long idle", 10 * time.Minute, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, advance := newTestCounter(t)
+			incr(t, c, "ip:1", 10)
+			advance(tt.advance)
+			if got := incr(t, c, "ip:1", 1); got != tt.want {
+				t.Errorf("count = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCounterBoundaryBurst(t *testing.T) {
+	// A fixed window would allow 5 at the end of one window and 5 more
+	// right after it; the sliding window still counts the first burst
+	c, _, advance := newTestCounter(t)
+	advance(59 * time.Second)
+	incr(t, c, "ip:1", 5)
+	advance(2 * time.Second)
+	if got := incr(t, c, "ip:1", 5); got < 9 {
+		t.Errorf("count after boundary burst = %d, want at least 9", got)
+	}
+}
+
+func TestCounterClientsAndExpiry(t *testing.T) {
+	c, srv, _ := newTestCounter(t)
+	if got := incr(t, c, "ip:1", 3); got != 3 {
+		t.Errorf("ip:1 count = %d, want 3", got)
+	}
+	if got := incr(t, c, "key:abc", 1); got != 1 {
+		t.Errorf("key:abc count = %d, want 1", got)
+	}
+
+	for _, key := range srv.Keys() {
+		if ttl := srv.TTL(key); ttl <= 0 || ttl > 2*time.Minute {
+			t.Errorf("%s TTL = %v, want up to two windows", key, ttl)
+		}
+	}
+}
+
+func TestCounterUnavailable(t *testing.T) {
+	c, srv, _ := newTestCounter(t)
+	srv.Close()
+	if _, err := c.Incr(context.Background(), "ip:1"); err == nil {
+		t.Error("Incr succeeded with Redis down")
+	}
+}
+
+func TestNewInvalidURL(t *testing.T) {
+	if _, err := New("http://localhost:6379", time.Second); err == nil {
+		t.Error("New accepted a non-redis URL")
+	}
+}
@@ -0,0 +1,26 @@
+// Package ratecount keeps rate limit counts in Redis, so every replica
+// behind a load balancer shares them.
+package ratecount
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// New creates a client for a redis:// or rediss:// URL, such as
+// redis://:password@localhost:6379/0. Connections are opened lazily and
+// every dial, read and write is bounded by timeout. Failed commands are not
+// retried: callers fail open rather than hold requests up.
+func New(rawURL string, timeout time.Duration) (*redis.Client, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	opts.DialTimeout = timeout
+	opts.ReadTimeout = timeout
+	opts.WriteTimeout = timeout
+	opts.MaxRetries = -1
+	opts.DialerRetries = 1 // a single attempt
+	return redis.NewClient(opts), nil
+}
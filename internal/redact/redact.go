@@ -0,0 +1,51 @@
+// Package redact masks secrets before values are written to logs.
+package redact
+
+import (
+	"net/url"
+	"strings"
+)
+
+// mask replaces sensitive values.
+const mask = "REDACTED"
+
+// sensitiveParams are query parameter names (or name fragments) that carry credentials.
+var sensitiveParams = []string{"token", "key", "sig", "auth", "secret", "password", "credential", "session"}
+
+// URL returns rawURL with sensitive query parameters and userinfo masked.
+// Unparseable input is returned fully masked.
+func URL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return mask
+	}
+
+	if parsed.User != nil {
+		parsed.User = url.User(mask)
+	}
+
+	query := parsed.Query()
+	changed := false
+	for name := range query {
+		if isSensitive(name) {
+			query.Set(name, mask)
+			changed = true
+		}
+	}
+	if changed {
+		parsed.RawQuery = query.Encode()
+	}
+
+	return parsed.String()
+}
+
+// isSensitive reports whether a query parameter name looks like a credential.
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveParams {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package sign
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	s := New([]byte("secret"))
+	valid := s.Query("1_abc.mp4", time.Hour)
+	expired := s.Query("1_abc.mp4", -time.Minute)
+
+	// with returns valid with key set to value
+	with := func(key, value string) url.Values {
+		q := url.Values{"exp": {valid.Get("exp")}, "sig": {valid.Get("sig")}}
+		q.Set(key, value)
+		return q
+	}
+	later := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		file   string
+		query  url.Values
+		want   error
+	}{
+		{"valid", s, "1_abc.mp4", valid, nil},
+		{"expired", s, "1_abc.mp4", expired, ErrExpired},
+		{"other file", s, "2_def.mp4", valid, ErrInvalid},
+		{"extended expiry", s, "1_abc.mp4", with("exp", later), ErrInvalid},
+		{"forged signature", s, "1_abc.mp4", with("sig", "AAAA"), ErrInvalid},
+		{"other secret", New([]byte("other")), "1_abc.mp4", valid, ErrInvalid},
+		{"missing signature", s, "1_abc.mp4", url.Values{"exp": {valid.Get("exp")}}, ErrInvalid},
+		{"missing expiry", s, "1_abc.mp4", url.Values{"sig": {valid.Get("sig")}}, ErrInvalid},
+		{"malformed expiry", s, "1_abc.mp4", with("exp", "soon"), ErrInvalid},
+		{"no query", s, "1_abc.mp4", url.Values{}, ErrInvalid},
+	}
+	for _, tt := range tests {
+		if err := tt.signer.Verify(tt.file, tt.query); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMACSeparatesFields(t *testing.T) {
+	// Moving characters between the name and the expiry must change the MAC
+	s := New([]byte("secret"))
+	if s.mac("a1", "23") == s.mac("a", "123") {
+		t.Error("mac(a1, 23) == mac(a, 123)")
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"io"
+	"sync"
+)
+
+// readerAtSeeker is a body the upload manager reads parts from in place,
+// without buffering them.
+type readerAtSeeker interface {
+	io.ReaderAt
+	io.ReadSeeker
+}
+
+// progressReader reports how much of a file the uploader has read. Parts
+// are read concurrently and may be read again for checksums or retries, so
+// it counts the furthest point read within each part rather than every byte.
+type progressReader struct {
+	readerAtSeeker
+	total    int64
+	partSize int64
+	fn       func(sent, total int64)
+
+	mu   sync.Mutex
+	read map[int64]int64 // part start -> furthest offset read
+	sent int64
+}
+
+func newProgressReader(r readerAtSeeker, total, partSize int64, fn func(sent, total int64)) *progressReader {
+	return &progressReader{readerAtSeeker: r, total: total, partSize: partSize, fn: fn, read: make(map[int64]int64)}
+}
+
+// ReadAt reads from the underlying file and reports any new progress.
+func (p *progressReader) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.readerAtSeeker.ReadAt(b, off)
+	if n > 0 {
+		p.advance(off, int64(n))
+	}
+	return n, err
+}
+
+// advance records that n bytes at off were read, calling fn when the
+// furthest point of their part moved. Calls to fn are serialized.
+func (p *progressReader) advance(off, n int64) {
+	start := off - off%p.partSize
+	end := off + n
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if end <= p.read[start] {
+		return
+	}
+	p.sent += end - max(p.read[start], start)
+	p.read[start] = end
+	p.fn(min(p.sent, p.total), p.total)
+}
@@ -3,34 +3,105 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/emanuelef/yt-dl-api-go/internal/sign"
+)
+
+// URL types an upload can be returned as.
+const (
+	// URLPresigned is a private, expiring link signed with the storage credentials.
+	URLPresigned = "presigned"
+	// URLPublic is a stable, cacheable link on a public bucket or path.
+	URLPublic = "public"
 )
 
+// ErrURLTypeUnsupported is returned when the storage cannot produce the requested URL type.
+var ErrURLTypeUnsupported = errors.New("storage does not support the requested URL type")
+
+// ErrFileNotFound is returned by Local.Open for missing or out-of-directory files.
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrFileForbidden is returned by Local.Open for links with a forged or
+// expired signature; it wraps the sign package error.
+var ErrFileForbidden = errors.New("file link not valid")
+
+// FilesPath is the route prefix Local serves stored files under.
+const FilesPath = "/api/files/"
+
+// UploadOptions describe how an uploaded file is linked and named.
+type UploadOptions struct {
+	// URLType is URLPresigned or URLPublic.
+	URLType string
+	// Title names the file browsers save, sanitized and given the file's
+	// extension. Empty uses the file's own name.
+	Title string
+	// Progress, when set, is called as the file is sent with the bytes
+	// sent so far and the file size. Calls are never concurrent.
+	Progress func(sent, total int64)
+}
+
+// R2Config holds R2 connection and upload settings.
+type R2Config struct {
+	AccountID       string
+	AccessKeyID     string
+	SecretAccessKey string
+	Bucket          string
+	// PublicURL enables URLPublic links when set.
+	PublicURL string
+	// PresignExpiry is the lifetime of URLPresigned links.
+	PresignExpiry time.Duration
+	// PartSize is the multipart upload part size; files up to one part are
+	// sent in a single request. Values under the S3 minimum of 5 MiB are raised.
+	PartSize int64
+	// UploadConcurrency is how many parts are uploaded at once.
+	UploadConcurrency int
+	// Endpoint overrides the account's R2 endpoint, for S3-compatible stores.
+	Endpoint string
+}
+
 // R2 implements Storage using Cloudflare R2.
 type R2 struct {
-	client    *s3.Client
-	bucket    string
-	publicURL string
+	client        *s3.Client
+	presign       *s3.PresignClient
+	uploader      *manager.Uploader
+	bucket        string
+	publicURL     string
+	presignExpiry time.Duration
+	partSize      int64
 }
 
 // NewR2 creates a new R2 storage client.
-func NewR2(ctx context.Context, accountID, accessKeyID, secretAccessKey, bucket, publicURL string) (*R2, error) {
-	if accountID == "" || accessKeyID == "" || secretAccessKey == "" {
+func NewR2(ctx context.Context, rc R2Config) (*R2, error) {
+	if rc.AccountID == "" || rc.AccessKeyID == "" || rc.SecretAccessKey == "" {
 		return nil, fmt.Errorf("R2 credentials not configured")
 	}
+	rc.PartSize = max(rc.PartSize, manager.MinUploadPartSize)
+	rc.UploadConcurrency = max(rc.UploadConcurrency, 1)
 
-	endpoint := fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID)
+	endpoint := rc.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.r2.cloudflarestorage.com", rc.AccountID)
+	}
 
 	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(rc.AccessKeyID, rc.SecretAccessKey, "")),
 		config.WithRegion("auto"),
 	)
 	if err != nil {
@@ -39,37 +110,100 @@ func NewR2(ctx context.Context, accountID, accessKeyID, secretAccessKey, bucket,
 
 	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = rc.Endpoint != ""
 	})
 
-	return &R2{client: client, bucket: bucket, publicURL: publicURL}, nil
+	return &R2{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+		// Files over one part are sent as a multipart upload, each part
+		// retried on its own and the upload aborted if one still fails
+		uploader: manager.NewUploader(client, func(u *manager.Uploader) {
+			u.PartSize = rc.PartSize
+			u.Concurrency = rc.UploadConcurrency
+		}),
+		bucket:        rc.Bucket,
+		publicURL:     strings.TrimSuffix(rc.PublicURL, "/"),
+		presignExpiry: rc.PresignExpiry,
+		partSize:      rc.PartSize,
+	}, nil
+}
+
+// SupportsURLType reports whether Upload can return urlType links.
+func (r *R2) SupportsURLType(urlType string) bool {
+	switch urlType {
+	case URLPresigned:
+		return true
+	case URLPublic:
+		return r.publicURL != ""
+	}
+	return false
+}
+
+// Check verifies the credentials and bucket by issuing a HeadBucket request.
+func (r *R2) Check(ctx context.Context) error {
+	_, err := r.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(r.bucket)})
+	if err != nil {
+		return fmt.Errorf("R2 bucket check failed: %w", err)
+	}
+	return nil
 }
 
-// Upload uploads a file to R2 and returns the public URL.
-func (r *R2) Upload(ctx context.Context, filePath string) (string, error) {
+// Upload uploads a file to R2 and returns a URL of the requested type.
+func (r *R2) Upload(ctx context.Context, filePath string, opts UploadOptions) (string, error) {
+	if !r.SupportsURLType(opts.URLType) {
+		return "", ErrURLTypeUnsupported
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
 
 	// Generate unique key
 	key := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(filePath))
+	contentType := aws.String(ContentType(filePath))
+	disposition := aws.String(ContentDisposition(filePath, opts.Title))
 
-	_, err = r.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(r.bucket),
-		Key:         aws.String(key),
-		Body:        file,
-		ContentType: aws.String(detectContentType(filePath)),
+	var body readerAtSeeker = file
+	if opts.Progress != nil {
+		body = newProgressReader(file, info.Size(), r.partSize, opts.Progress)
+	}
+	_, err = r.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:             aws.String(r.bucket),
+		Key:                aws.String(key),
+		Body:               body,
+		ContentType:        contentType,
+		ContentDisposition: disposition,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to upload to R2: %w", err)
 	}
 
-	// Build public URL
-	if r.publicURL != "" {
+	if opts.URLType == URLPublic {
 		return fmt.Sprintf("%s/%s", r.publicURL, key), nil
 	}
-	return fmt.Sprintf("https://%s.r2.dev/%s", r.bucket, key), nil
+	return r.presignGet(ctx, key, disposition)
+}
+
+// presignGet returns a presigned GET URL for key. The disposition is also
+// signed into the URL, so the saved name holds even if the object's
+// metadata is lost in a copy.
+func (r *R2) presignGet(ctx context.Context, key string, disposition *string) (string, error) {
+	req, err := r.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(r.bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: disposition,
+	}, s3.WithPresignExpires(r.presignExpiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign URL: %w", err)
+	}
+	return req.URL, nil
 }
 
 // Cleanup removes a local file.
@@ -77,22 +211,123 @@ func (r *R2) Cleanup(filePath string) error {
 	return os.Remove(filePath)
 }
 
+// LocalConfig holds local storage settings.
+type LocalConfig struct {
+	Dir string
+	// BaseURL prefixes FilesPath links; links are relative when empty.
+	BaseURL string
+	// Signer signs file links, which then expire after LinkExpiry. Links
+	// are unsigned and permanent when nil.
+	Signer     *sign.Signer
+	LinkExpiry time.Duration
+}
+
 // Local implements Storage using local filesystem.
 type Local struct {
-	dir string
+	dir        string
+	baseURL    string
+	signer     *sign.Signer
+	linkExpiry time.Duration
+}
+
+// NewLocal creates a new local storage. Files are linked under FilesPath.
+func NewLocal(lc LocalConfig) *Local {
+	os.MkdirAll(lc.Dir, 0755)
+	return &Local{
+		dir:        lc.Dir,
+		baseURL:    strings.TrimSuffix(lc.BaseURL, "/"),
+		signer:     lc.Signer,
+		linkExpiry: lc.LinkExpiry,
+	}
+}
+
+// Check verifies that the storage directory exists.
+func (l *Local) Check(ctx context.Context) error {
+	info, err := os.Stat(l.dir)
+	if err != nil {
+		return fmt.Errorf("storage directory unavailable: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("storage path %s is not a directory", l.dir)
+	}
+	return nil
+}
+
+// SupportsURLType reports whether Upload can return urlType links. Local
+// links are always URLPublic: when a signer is set they carry an expiring
+// HMAC signature, but there are no storage credentials to presign with.
+func (l *Local) SupportsURLType(urlType string) bool {
+	return urlType == URLPublic
 }
 
-// NewLocal creates a new local storage.
-func NewLocal(dir string) *Local {
-	os.MkdirAll(dir, 0755)
-	return &Local{dir: dir}
+// Upload leaves the file in place and returns its FilesPath link. The
+// title travels in the link's "title" parameter, for Content-Disposition.
+func (l *Local) Upload(ctx context.Context, filePath string, opts UploadOptions) (string, error) {
+	if !l.SupportsURLType(opts.URLType) {
+		return "", ErrURLTypeUnsupported
+	}
+	name, ok := l.contained(filePath)
+	if !ok {
+		return "", fmt.Errorf("file %s is outside the storage directory", filePath)
+	}
+	query := url.Values{}
+	if l.signer != nil {
+		query = l.signer.Query(name, l.linkExpiry)
+	}
+	if title := sanitizeTitle(opts.Title); title != "" {
+		query.Set("title", title)
+	}
+	link := l.baseURL + FilesPath + url.PathEscape(name)
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link, nil
 }
 
-// Upload copies file and returns a local path (for development).
-func (l *Local) Upload(ctx context.Context, filePath string) (string, error) {
-	// In local mode, just return the file path as-is
-	// In production, you'd want a proper file server
-	return filePath, nil
+// Open opens a stored file by name for serving, after checking the link's
+// signature in query when links are signed. Names that are not a plain
+// file directly inside the storage directory, and partial downloads, are
+// reported as ErrFileNotFound.
+func (l *Local) Open(name string, query url.Values) (*os.File, error) {
+	if l.signer != nil {
+		if err := l.signer.Verify(name, query); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFileForbidden, err)
+		}
+	}
+	if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".part") {
+		return nil, ErrFileNotFound
+	}
+	path := filepath.Join(l.dir, name)
+	if contained, ok := l.contained(path); !ok || contained != name {
+		return nil, ErrFileNotFound
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, ErrFileNotFound
+	}
+	return file, nil
+}
+
+// contained returns the name of filePath within the storage directory,
+// reporting false unless it sits directly inside it.
+func (l *Local) contained(filePath string) (string, bool) {
+	dir, err := filepath.Abs(l.dir)
+	if err != nil {
+		return "", false
+	}
+	path, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel != filepath.Base(rel) || rel == "." || rel == ".." {
+		return "", false
+	}
+	return rel, true
 }
 
 // Cleanup does nothing for local storage (file should be served first).
@@ -102,21 +337,118 @@ func (l *Local) Cleanup(filePath string) error {
 	return nil
 }
 
-// detectContentType returns MIME type based on file extension.
-func detectContentType(filePath string) string {
-	ext := filepath.Ext(filePath)
-	switch ext {
-	case ".mp4":
-		return "video/mp4"
-	case ".webm":
-		return "video/webm"
-	case ".mkv":
-		return "video/x-matroska"
-	case ".mp3":
-		return "audio/mpeg"
-	case ".m4a":
-		return "audio/mp4"
-	default:
+// contentTypes maps every extension the downloader can produce to its MIME type.
+var contentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".flac": "audio/flac",
+	".opus": "audio/ogg",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+	".srt":  "application/x-subrip",
+	".vtt":  "text/vtt",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+}
+
+// ContentType returns the MIME type for a file based on its extension,
+// falling back to sniffing the file's first bytes for unknown extensions.
+func ContentType(filePath string) string {
+	if ct, ok := contentTypes[strings.ToLower(filepath.Ext(filePath))]; ok {
+		return ct
+	}
+	return sniffContentType(filePath)
+}
+
+// sniffContentType detects the MIME type from the start of the file.
+func sniffContentType(filePath string) string {
+	f, err := os.Open(filePath)
+	if err != nil {
 		return "application/octet-stream"
 	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// ContentDisposition returns the attachment header for a stored file,
+// named after title when it has one, else after the file without the
+// downloader's timestamp prefix.
+func ContentDisposition(filePath, title string) string {
+	if title = sanitizeTitle(title); title != "" {
+		return contentDisposition(title + filepath.Ext(filePath))
+	}
+	return contentDisposition(displayName(filePath))
+}
+
+// maxTitleBytes caps sanitized titles, leaving room for an extension
+// within the common 255 byte file name limit.
+const maxTitleBytes = 200
+
+// sanitizeTitle makes a video title usable as a file name: control,
+// invalid and path or Windows reserved characters become spaces, runs of
+// whitespace collapse, and leading and trailing dots and spaces go.
+func sanitizeTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || !unicode.IsPrint(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return ' '
+		}
+		return r
+	}, title)
+	title = strings.Trim(strings.Join(strings.Fields(title), " "), ". ")
+	if len(title) > maxTitleBytes {
+		cut := maxTitleBytes
+		for cut > 0 && !utf8.RuneStart(title[cut]) {
+			cut--
+		}
+		title = strings.Trim(title[:cut], ". ")
+	}
+	return title
+}
+
+// displayName strips the "<timestamp>_" prefix the downloader adds to files.
+func displayName(filePath string) string {
+	base := filepath.Base(filePath)
+	prefix, rest, ok := strings.Cut(base, "_")
+	if !ok || rest == "" || strings.Trim(prefix, "0123456789") != "" {
+		return base
+	}
+	return rest
+}
+
+// contentDisposition builds an attachment header with an ASCII fallback
+// filename and an RFC 5987 encoded filename* for non-ASCII names.
+func contentDisposition(name string) string {
+	var ascii, encoded strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '/' {
+			ascii.WriteByte('_')
+		} else {
+			ascii.WriteRune(r)
+		}
+	}
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii.String(), encoded.String())
+}
+
+// isAttrChar reports whether b may appear unescaped in an RFC 5987 value.
+func isAttrChar(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
 }
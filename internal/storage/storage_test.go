@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestR2Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"reachable", http.StatusOK, false},
+		{"bad credentials", http.StatusForbidden, true},
+		{"missing bucket", http.StatusNotFound, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			r := newTestR2(t, srv)
+
+			err := r.Check(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check = %v, want error %v", err, tt.wantErr)
+			}
+			if method != http.MethodHead || path != "/bucket" {
+				t.Errorf("request = %s %s, want HEAD /bucket", method, path)
+			}
+		})
+	}
+}
+
+func TestSanitizeTitle(t *testing.T) {
+	tests := []struct {
+		title, want string
+	}{
+		{"My Video", "My Video"},
+		{"  spaced   out  ", "spaced out"},
+		{"a/b\\c:d*e?f\"g<h>i|j", "a b c d e f g h i j"},
+		{"line\nbreak\ttab\x00nul", "line break tab nul"},
+		{"...hidden.", "hidden"},
+		{"Ünïcødé 日本語 🎬", "Ünïcødé 日本語 🎬"},
+		{"bad\xffbyte", "bad byte"},
+		{"///", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeTitle(tt.title); got != tt.want {
+			t.Errorf("sanitizeTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeTitleLength(t *testing.T) {
+	got := sanitizeTitle(strings.Repeat("日", 100)) // 300 bytes
+	if len(got) > maxTitleBytes || !strings.HasPrefix(got, "日") {
+		t.Fatalf("len = %d, want <= %d", len(got), maxTitleBytes)
+	}
+	if strings.ContainsRune(got, '�') {
+		t.Fatal("truncation split a rune")
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		path, title, want string
+	}{
+		{"/tmp/1700000000_abc.mp4", "", `attachment; filename="abc.mp4"; filename*=UTF-8''abc.mp4`},
+		{"/tmp/1700000000_abc.mp4", "My Video", `attachment; filename="My Video.mp4"; filename*=UTF-8''My%20Video.mp4`},
+		{"/tmp/1_abc.mp3", "Café \"live\"", `attachment; filename="Caf_ live.mp3"; filename*=UTF-8''Caf%C3%A9%20live.mp3`},
+		{"/tmp/1_abc.webm", "../../etc/passwd", `attachment; filename="etc passwd.webm"; filename*=UTF-8''etc%20passwd.webm`},
+	}
+	for _, tt := range tests {
+		if got := ContentDisposition(tt.path, tt.title); got != tt.want {
+			t.Errorf("ContentDisposition(%q, %q) =\n %s\nwant\n %s", tt.path, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestLocalUploadTitle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "1_abc.mp4")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLocal(LocalConfig{Dir: dir})
+
+	link, err := l.Upload(context.Background(), path, UploadOptions{URLType: URLPublic, Title: "My/Video"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != FilesPath+"1_abc.mp4" || u.Query().Get("title") != "My Video" {
+		t.Errorf("link = %s", link)
+	}
+}
+
+func TestPresignIncludesDisposition(t *testing.T) {
+	r, err := NewR2(context.Background(), R2Config{
+		AccountID:       "account",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		Bucket:          "bucket",
+		PresignExpiry:   time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disposition := ContentDisposition("/tmp/1_abc.mp4", "Café")
+	link, err := r.presignGet(context.Background(), "1_abc.mp4", aws.String(disposition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("response-content-disposition"); got != disposition {
+		t.Errorf("response-content-disposition = %q, want %q", got, disposition)
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an S3 server holding objects and multipart uploads in memory.
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	parts     map[int][]byte
+	puts      int
+	created   int
+	completed int
+	aborted   int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	s := &fakeS3{objects: make(map[string][]byte), parts: make(map[int][]byte)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.created++
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`, key)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		s.parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var done struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		xml.Unmarshal(body, &done)
+		var data []byte
+		for _, p := range done.Parts {
+			data = append(data, s.parts[p.PartNumber]...)
+		}
+		s.objects[key] = data
+		s.completed++
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+		s.puts++
+		w.Header().Set("ETag", `"put"`)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// newTestR2 returns an R2 storing into s3 with 5 MiB parts.
+func newTestR2(t *testing.T, srv *httptest.Server) *R2 {
+	t.Helper()
+	r, err := NewR2(context.Background(), R2Config{
+		AccountID:         "account",
+		AccessKeyID:       "key",
+		SecretAccessKey:   "secret",
+		Bucket:            "bucket",
+		PublicURL:         "https://cdn.test",
+		UploadConcurrency: 2,
+		Endpoint:          srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// writeTestFile writes size bytes of varied content and returns its path and content.
+func writeTestFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	path := filepath.Join(t.TempDir(), "1_abc.mp4")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestR2UploadMultipart(t *testing.T) {
+	s3, srv := newFakeS3(t)
+	r := newTestR2(t, srv)
+	path, data := writeTestFile(t, 12<<20) // three 5 MiB parts
+
+	var progress []int64
+	link, err := r.Upload(context.Background(), path, UploadOptions{
+		URLType:  URLPublic,
+		Progress: func(sent, total int64) { progress = append(progress, sent) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := strings.TrimPrefix(link, "https://cdn.test/")
+	if s3.created != 1 || s3.completed != 1 || s3.puts != 0 || s3.aborted != 0 {
+		t.Errorf("created %d, completed %d, puts %d, aborted %d", s3.created, s3.completed, s3.puts, s3.aborted)
+	}
+	var numbers []int
+	for n := range s3.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	if fmt.Sprint(numbers) != "[1 2 3]" {
+		t.Errorf("parts = %v, want [1 2 3]", numbers)
+	}
+	if !bytes.Equal(s3.objects[key], data) {
+		t.Errorf("stored object differs from the file (%d bytes)", len(s3.objects[key]))
+	}
+
+	if len(progress) == 0 || progress[len(progress)-1] != int64(len(data)) {
+		t.Fatalf("progress = %v, want to end at %d", progress, len(data))
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Fatalf("progress went from %d to %d", progress[i-1], progress[i])
+		}
+	}
+}
+
+func TestR2UploadSinglePart(t *testing.T) {
+	s3, srv := newFakeS3(t)
+	r := newTestR2(t, srv)
+	path, data := writeTestFile(t, 1<<20)
+
+	var last int64
+	link, err := r.Upload(context.Background(), path, UploadOptions{
+		URLType:  URLPublic,
+		Progress: func(sent, total int64) { last = sent },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.TrimPrefix(link, "https://cdn.test/")
+	if s3.puts != 1 || s3.created != 0 {
+		t.Errorf("puts %d, multipart uploads %d; want one PutObject", s3.puts, s3.created)
+	}
+	if !bytes.Equal(s3.objects[key], data) || last != int64(len(data)) {
+		t.Errorf("stored %d bytes, progress %d, want %d", len(s3.objects[key]), last, len(data))
+	}
+}
+
+func TestProgressReaderRereads(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 100)
+	var got []int64
+	p := newProgressReader(bytes.NewReader(data), 100, 40, func(sent, total int64) { got = append(got, sent) })
+	buf := make([]byte, 20)
+
+	p.ReadAt(buf, 0)  // part 1
+	p.ReadAt(buf, 0)  // part 1 again, as for a retry
+	p.ReadAt(buf, 40) // part 2
+	p.ReadAt(buf, 20) // rest of part 1
+	p.ReadAt(buf, 80) // part 3
+	if fmt.Sprint(got) != "[20 40 60 80]" {
+		t.Errorf("progress = %v, want [20 40 60 80]", got)
+	}
+}
@@ -0,0 +1,103 @@
+// Package urlprep canonicalizes video URLs before they are validated, so
+// that mobile, shortlink and tracking-laden forms of a URL all reach the
+// downloader in one shape.
+package urlprep
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Step rewrites a parsed URL in place.
+type Step func(u *url.URL)
+
+// Pipeline applies its steps in order.
+type Pipeline []Step
+
+// Default is the pipeline used for incoming download and info requests.
+var Default = Pipeline{MobileToDesktop, ExpandYouTubeShortlink, StripTracking}
+
+// Apply runs every step on rawURL. Unparseable or non-absolute input is
+// returned unchanged and left for validation to reject.
+func (p Pipeline) Apply(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	for _, step := range p {
+		step(parsed)
+	}
+	return parsed.String()
+}
+
+// desktopHosts maps mobile hosts to their desktop equivalents.
+var desktopHosts = map[string]string{
+	"m.youtube.com":      "www.youtube.com",
+	"mobile.twitter.com": "twitter.com",
+	"mobile.x.com":       "x.com",
+	"m.facebook.com":     "www.facebook.com",
+	"m.twitch.tv":        "www.twitch.tv",
+	"m.vimeo.com":        "vimeo.com",
+	"m.reddit.com":       "www.reddit.com",
+}
+
+// MobileToDesktop replaces known mobile hosts with the desktop site.
+func MobileToDesktop(u *url.URL) {
+	host := strings.ToLower(u.Hostname())
+	if desktop, ok := desktopHosts[host]; ok {
+		u.Host = withPort(desktop, u.Port())
+	}
+}
+
+// ExpandYouTubeShortlink rewrites youtu.be/ID to youtube.com/watch?v=ID,
+// keeping any other query parameters such as the start time.
+func ExpandYouTubeShortlink(u *url.URL) {
+	if strings.ToLower(u.Hostname()) != "youtu.be" {
+		return
+	}
+	id := strings.Trim(u.Path, "/")
+	if id == "" || strings.Contains(id, "/") {
+		return
+	}
+
+	rawQuery := "v=" + url.QueryEscape(id)
+	if u.RawQuery != "" {
+		rawQuery += "&" + u.RawQuery
+	}
+	u.Host = withPort("www.youtube.com", u.Port())
+	u.Path = "/watch"
+	u.RawPath = ""
+	u.RawQuery = rawQuery
+}
+
+// trackingParams are query parameters that only identify the referrer.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "igshid": true, "igsh": true,
+	"si": true, "feature": true, "pp": true, "ref_src": true, "ref_url": true,
+}
+
+// StripTracking removes utm_* and other tracking query parameters. The
+// remaining parameters are kept byte for byte rather than re-encoded.
+func StripTracking(u *url.URL) {
+	if u.RawQuery == "" {
+		return
+	}
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		name = strings.ToLower(name)
+		if trackingParams[name] || strings.HasPrefix(name, "utm_") {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	u.RawQuery = strings.Join(kept, "&")
+}
+
+// withPort joins host and port, omitting an empty port.
+func withPort(host, port string) string {
+	if port == "" {
+		return host
+	}
+	return host + ":" + port
+}
@@ -0,0 +1,101 @@
+package urlprep
+
+import (
+	"net/url"
+	"testing"
+)
+
+// applyStep runs a single step on rawURL.
+func applyStep(step Step, rawURL string) string {
+	return Pipeline{step}.Apply(rawURL)
+}
+
+func TestMobileToDesktop(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://m.youtube.com/watch?v=abc", "https://www.youtube.com/watch?v=abc"},
+		{"https://M.YouTube.com/watch?v=abc", "https://www.youtube.com/watch?v=abc"},
+		{"https://mobile.twitter.com/u/status/1", "https://twitter.com/u/status/1"},
+		{"https://m.reddit.com:8443/r/videos", "https://www.reddit.com:8443/r/videos"},
+		{"https://www.youtube.com/watch?v=abc", "https://www.youtube.com/watch?v=abc"},
+		{"https://m.example.com/video", "https://m.example.com/video"},
+	}
+	for _, tt := range tests {
+		if got := applyStep(MobileToDesktop, tt.in); got != tt.want {
+			t.Errorf("MobileToDesktop(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandYouTubeShortlink(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://youtu.be/abc", "https://www.youtube.com/watch?v=abc"},
+		{"https://youtu.be/abc/", "https://www.youtube.com/watch?v=abc"},
+		{"https://youtu.be/abc?t=42", "https://www.youtube.com/watch?v=abc&t=42"},
+		{"https://YOUTU.BE/abc", "https://www.youtube.com/watch?v=abc"},
+		{"https://youtu.be/", "https://youtu.be/"},
+		{"https://youtu.be/a/b", "https://youtu.be/a/b"},
+		{"https://vimeo.com/123", "https://vimeo.com/123"},
+	}
+	for _, tt := range tests {
+		if got := applyStep(ExpandYouTubeShortlink, tt.in); got != tt.want {
+			t.Errorf("ExpandYouTubeShortlink(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripTracking(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://www.youtube.com/watch?v=abc&si=xyz", "https://www.youtube.com/watch?v=abc"},
+		{"https://x.com/u/status/1?utm_source=share&UTM_Medium=a&s=20", "https://x.com/u/status/1?s=20"},
+		{"https://www.instagram.com/reel/abc/?igsh=1&fbclid=2", "https://www.instagram.com/reel/abc/"},
+		{"https://vimeo.com/1?h=a%2Fb&feature=share", "https://vimeo.com/1?h=a%2Fb"},
+		{"https://vimeo.com/1?sig=a+b", "https://vimeo.com/1?sig=a+b"},
+		{"https://vimeo.com/1", "https://vimeo.com/1"},
+	}
+	for _, tt := range tests {
+		if got := applyStep(StripTracking, tt.in); got != tt.want {
+			t.Errorf("StripTracking(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultPipeline(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://youtu.be/abc?si=share&t=10", "https://www.youtube.com/watch?v=abc&t=10"},
+		{"https://m.youtube.com/watch?v=abc&feature=youtu.be&utm_campaign=x", "https://www.youtube.com/watch?v=abc"},
+		{"https://mobile.x.com/u/status/1?ref_src=twsrc", "https://x.com/u/status/1"},
+		{"https://www.tiktok.com/@u/video/1?is_from_webapp=1", "https://www.tiktok.com/@u/video/1?is_from_webapp=1"},
+		// Left for validation to reject
+		{"youtube.com/watch?v=abc", "youtube.com/watch?v=abc"},
+		{"http://[::1:bad", "http://[::1:bad"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Default.Apply(tt.in); got != tt.want {
+			t.Errorf("Default.Apply(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		// Canonical URLs are a fixed point
+		if got := Default.Apply(tt.want); got != tt.want {
+			t.Errorf("Default.Apply(%q) = %q, want it unchanged", tt.want, got)
+		}
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	var order []string
+	step := func(name string) Step {
+		return func(u *url.URL) { order = append(order, name) }
+	}
+	Pipeline{step("a"), step("b"), step("c")}.Apply("https://example.com/")
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "c" {
+		t.Errorf("steps ran in order %v", order)
+	}
+}